@@ -1,15 +1,27 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/sirUnchained/udemy-backend-course/docs"
 	"github.com/sirUnchained/udemy-backend-course/internal/auth"
+	"github.com/sirUnchained/udemy-backend-course/internal/blob"
+	"github.com/sirUnchained/udemy-backend-course/internal/chat"
+	"github.com/sirUnchained/udemy-backend-course/internal/mailer"
+	"github.com/sirUnchained/udemy-backend-course/internal/ratelimiter"
 	"github.com/sirUnchained/udemy-backend-course/internal/store"
+	"github.com/sirUnchained/udemy-backend-course/internal/store/cache"
+	"github.com/sirUnchained/udemy-backend-course/internal/stream"
 	httpSwagger "github.com/swaggo/http-swagger"
 	"go.uber.org/zap"
 )
@@ -17,16 +29,55 @@ import (
 type application struct {
 	config        config
 	store         store.Storage
+	cacheStorage  cache.Storage
 	logger        *zap.SugaredLogger
 	authenticator auth.Authenticator
+	mailer        mailer.Client
+	lifecycle     *lifecycle
+	hub           *stream.Hub
+	chat          *chat.Gateway
+	blobStore     blob.BlobStore
+	rateLimiters  map[string]ratelimiter.Limiter
 }
 
 type config struct {
-	addr   string
-	db     dbConfig
-	apiURL string
-	mail   mailConfig
-	auth   authConfig
+	addr        string
+	db          dbConfig
+	apiURL      string
+	frontendURL string // with the scheme, links in emails point here
+	mail        mailConfig
+	auth        authConfig
+	cache       cacheConfig
+	server      serverConfig
+	rateLimiter rateLimiterConfig
+	blob        blobConfig
+	// the load balancers in front of us, only they can tell us the ip of the client
+	trustedProxies []*net.IPNet
+}
+
+type blobConfig struct {
+	backend  string // "s3", anything else keeps the files on the local disk
+	localDir string
+	// the url the local files are served on, the s3 store uses s3.PublicURL
+	localURL string
+	s3       blob.S3Config
+}
+
+type rateLimiterConfig struct {
+	enabled  bool
+	backend  string // "memory" or "redis"
+	strategy string // "fixed" or "token", the redis backend always uses fixed windows
+	auth     ratelimiter.Config
+	public   ratelimiter.Config
+	user     ratelimiter.Config
+}
+
+type serverConfig struct {
+	// how long we keep answering (and report "draining" on /health)
+	// before we stop accepting connections, gives the load balancer time to notice
+	drainDelay time.Duration
+	// how long in-flight requests get to finish once we stop accepting connections
+	shutdownTimeout time.Duration
 }
 
 type dbConfig struct {
@@ -36,8 +87,38 @@ type dbConfig struct {
 	maxIdleTime  string
 }
 
+// backend is one of "memory" or "redis", anything else disables the cache
+type cacheConfig struct {
+	backend  string
+	ttl      time.Duration
+	capacity int // only used by the memory backend
+	redis    redisConfig
+}
+
+type redisConfig struct {
+	addr string
+	pw   string
+	db   int
+}
+
+func (c cacheConfig) enabled() bool {
+	return c.backend == "memory" || c.backend == "redis"
+}
+
+// sink is "smtp" for real delivery, anything else writes emails to the log
 type mailConfig struct {
-	exp time.Duration
+	exp       time.Duration
+	resetExp  time.Duration // how long a password reset link works
+	fromEmail string
+	sink      string
+	smtp      smtpConfig
+}
+
+type smtpConfig struct {
+	host     string
+	port     int
+	username string
+	password string
 }
 
 type authConfig struct {
@@ -51,10 +132,13 @@ type basicConfig struct {
 }
 
 type tokenConfig struct {
-	secret string
-	exp    time.Duration
-	aud    string
-	iss    string
+	secret     string
+	keysDir    string        // when set we sign with the asymmetric keys in it instead of secret
+	exp        time.Duration // access token lifetime, keep it short
+	refreshExp time.Duration
+	aud        string
+	iss        string
+	leeway     time.Duration // clock skew we accept on exp and nbf
 }
 
 func (app *application) mount() http.Handler {
@@ -62,60 +146,147 @@ func (app *application) mount() http.Handler {
 
 	// recovers from panics and returns 500 error
 	r.Use(middleware.Recoverer)
+	// keeps access tokens sent in the url out of the logs
+	r.Use(app.QueryTokenMiddleware)
 	// a simple logger for HTTP requests
 	r.Use(middleware.Logger)
-	// sets real IP from X-Real-IP or X-Forwarded-For headers
-	r.Use(middleware.RealIP)
+	// sets real IP from X-Forwarded-For or X-Real-IP, only when a trusted proxy sent them
+	r.Use(app.RealIPMiddleware)
 	// adds a unique request ID to each request
 	r.Use(middleware.RequestID)
-	// sets timeout for requests to prevent hanging connections
-	r.Use((middleware.Timeout(time.Second * 60)))
+	// sets timeout for requests to prevent hanging connections,
+	// not global because the event stream has to stay open
+	timeout := middleware.Timeout(time.Second * 60)
+
+	// public keys for everyone who wants to verify our tokens
+	r.With(timeout).Get("/.well-known/jwks.json", app.jwksHandler)
 
 	// API v1 routes
 	r.Route("/v1", func(r chi.Router) {
-		r.Get("/health", app.healthCheckHandler)
+		// long lived connections, they have no timeout and set their own deadlines
+		r.Group(func(r chi.Router) {
+			r.Use(app.StreamAuthMiddleware)
+			r.Use(app.RateLimiterMiddleware(rateLimitUser, app.keyByUser))
+			r.Get("/stream", app.streamHandler)
+			r.Get("/chat", app.chatHandler)
+		})
+
+		r.Group(func(r chi.Router) {
+			r.Use(timeout)
+
+			r.Get("/health", app.healthCheckHandler)
 
-		docsURL := fmt.Sprintf("%s/swagger/doc.json", app.config.addr)
-		r.Get("/swagger/*", httpSwagger.Handler(httpSwagger.URL(docsURL)))
+			docsURL := fmt.Sprintf("%s/swagger/doc.json", app.config.addr)
+			r.Get("/swagger/*", httpSwagger.Handler(httpSwagger.URL(docsURL)))
+
+			r.With(app.RateLimiterMiddleware(rateLimitPublic, keyByIP)).Get("/media/*", app.getMediaHandler)
+
+			r.Route("/posts", func(r chi.Router) {
+				r.Use(app.AuthTokenMiddleware)
+				r.Use(app.RateLimiterMiddleware(rateLimitUser, app.keyByUser))
+				r.Post("/", app.createPostHandler)
 
-		r.Route("/posts", func(r chi.Router) {
-			r.Use(app.AuthTokenMiddleware)
-			r.Post("/", app.createPostHandler)
+				r.Route("/{postid}", func(r chi.Router) {
+					r.Use(app.postsContextMiddleware)
 
-			r.Route("/{postid}", func(r chi.Router) {
-				r.Use(app.postsContextMiddleware)
+					r.Get("/", app.getPostByIdHandler)
+					r.Delete("/", app.checkPostOwnership(store.RoleModerator, app.deletePostByIdHandler))
+					r.Patch("/", app.checkPostOwnership(store.RoleAdmin, app.updatePostByIdHandler))
 
-				r.Get("/", app.getPostByIdHandler)
-				r.Delete("/", app.deletePostByIdHandler)
-				r.Patch("/", app.updatePostByIdHandler)
+					r.Post("/attachments", app.checkPostOwnership(store.RoleAdmin, app.uploadAttachmentsHandler))
+					r.Delete("/attachments/{attachmentid}", app.checkPostOwnership(store.RoleModerator, app.deleteAttachmentHandler))
+
+					r.Put("/reactions/{kind}", app.addReactionHandler)
+					r.Delete("/reactions/{kind}", app.removeReactionHandler)
+
+					r.Route("/comments", func(r chi.Router) {
+						r.Get("/", app.listCommentsHandler)
+						r.Post("/", app.createCommentHandler)
+
+						r.Route("/{commentid}", func(r chi.Router) {
+							r.Use(app.commentContextMiddleware)
+
+							r.Patch("/", app.checkCommentOwnership(store.RoleAdmin, app.updateCommentHandler))
+							r.Delete("/", app.checkCommentOwnership(store.RoleModerator, app.deleteCommentHandler))
+						})
+					})
+				})
 			})
-		})
 
-		r.Route("/comments", func(r chi.Router) {
-			r.Post("/post/{postid}", app.createCommentHandler)
-		})
+			r.Route("/users", func(r chi.Router) {
+				r.With(app.RateLimiterMiddleware(rateLimitPublic, keyByIP)).Put("/activate/{token}", app.activateUserHandler)
 
-		r.Route("/users", func(r chi.Router) {
-			r.Put("/activate/{token}", app.activateUserHandler)
+				r.Route("/{userid}", func(r chi.Router) {
+					r.Use(app.AuthTokenMiddleware)
+					r.Use(app.RateLimiterMiddleware(rateLimitUser, app.keyByUser))
+					r.Use(app.userContextMiddleware)
 
-			r.Route("/{userid}", func(r chi.Router) {
+					r.Get("/", app.getUserHandler)
+					r.Put("/follow", app.followUserHandler)
+					r.Put("/unfollow", app.unfollowUserHandler)
+					r.Get("/followers", app.listFollowersHandler)
+					r.Get("/following", app.listFollowingHandler)
+					r.Put("/block", app.blockUserHandler)
+					r.Delete("/block", app.unblockUserHandler)
+					r.Put("/mute", app.muteUserHandler)
+					r.Delete("/mute", app.unmuteUserHandler)
+					r.Post("/messages", app.sendMessageHandler)
+				})
+
+				r.Group(func(r chi.Router) {
+					r.Use(app.AuthTokenMiddleware)
+					r.Use(app.RateLimiterMiddleware(rateLimitUser, app.keyByUser))
+					r.Get("/me", app.getMyProfileHandler)
+					r.Patch("/me", app.updateMyProfileHandler)
+					r.Get("/feed", app.getUserFeedHandler)
+					r.Get("/suggestions", app.suggestedUsersHandler)
+				})
+
+			})
+
+			r.Route("/notifications", func(r chi.Router) {
+				r.Use(app.AuthTokenMiddleware)
+				r.Use(app.RateLimiterMiddleware(rateLimitUser, app.keyByUser))
+				r.Get("/", app.listNotificationsHandler)
+				r.Post("/read", app.markNotificationsReadHandler)
+			})
+
+			r.Route("/conversations", func(r chi.Router) {
 				r.Use(app.AuthTokenMiddleware)
-				// r.Use(app.userContextMiddleware)
+				r.Use(app.RateLimiterMiddleware(rateLimitUser, app.keyByUser))
+				r.Get("/", app.listConversationsHandler)
 
-				r.Get("/", app.getUserHandler)
-				r.Put("/follow", app.followUserHandler)
-				r.Put("/unfollow", app.unfollowUserHandler)
+				r.Route("/{conversationid}", func(r chi.Router) {
+					r.Use(app.conversationContextMiddleware)
+					r.Get("/messages", app.listMessagesHandler)
+					r.Post("/read", app.markConversationReadHandler)
+				})
 			})
 
-			r.Group(func(r chi.Router) {
-				r.Get("/feed", app.getUserFeedHandler)
+			r.Route("/tags", func(r chi.Router) {
+				r.Use(app.AuthTokenMiddleware)
+				r.Use(app.RateLimiterMiddleware(rateLimitUser, app.keyByUser))
+				r.Get("/trending", app.trendingTagsHandler)
+				r.Get("/{tag}/posts", app.getTagPostsHandler)
 			})
 
-		})
+			r.Route("/search", func(r chi.Router) {
+				r.Use(app.AuthTokenMiddleware)
+				r.Use(app.RateLimiterMiddleware(rateLimitUser, app.keyByUser))
+				r.Get("/posts", app.searchPostsHandler)
+				r.Get("/users", app.searchUsersHandler)
+			})
 
-		r.Route("/authentication", func(r chi.Router) {
-			r.Post("/user", app.registerUserHandler)
-			r.Post("/token", app.createTokenHandler)
+			r.Route("/authentication", func(r chi.Router) {
+				r.Use(app.RateLimiterMiddleware(rateLimitAuth, keyByIP))
+				r.Post("/user", app.registerUserHandler)
+				r.Post("/token", app.createTokenHandler)
+				r.Post("/refresh", app.refreshTokenHandler)
+				r.Post("/forgot-password", app.forgotPasswordHandler)
+				r.Post("/reset-password", app.resetPasswordHandler)
+				r.With(app.AuthTokenMiddleware).Post("/logout", app.logoutHandler)
+				r.With(app.AuthTokenMiddleware).Post("/change-password", app.changePasswordHandler)
+			})
 		})
 	})
 
@@ -138,9 +309,56 @@ func (app *application) run(mux http.Handler) error {
 		IdleTimeout:  time.Minute * 1,  // maximum idle connection timeout
 	}
 
+	// Shutdown doesn't wait for streams to go idle, they never do, so end them ourselves
+	srv.RegisterOnShutdown(app.hub.Close)
+	// websockets are hijacked, Shutdown doesn't even know about them
+	srv.RegisterOnShutdown(app.chat.Close)
+
+	shutdownErr := make(chan error, 1)
+
+	go func() {
+		quit := make(chan os.Signal, 1)
+		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+		sig := <-quit
+
+		app.logger.Infow("shutting down server", "signal", sig.String())
+
+		// tell the load balancer we are going away, but keep serving for a while
+		app.lifecycle.ready.Store(false)
+		time.Sleep(app.config.server.drainDelay)
+
+		ctx, cancel := context.WithTimeout(context.Background(), app.config.server.shutdownTimeout)
+		defer cancel()
+
+		// stop accepting connections and wait for in-flight requests
+		err := srv.Shutdown(ctx)
+
+		// then stop everything that runs in the background, even if Shutdown timed out,
+		// main closes the database right after we return. they get their own timeout
+		// because ctx is already done when Shutdown gave up.
+		bgCtx, bgCancel := context.WithTimeout(context.Background(), app.config.server.shutdownTimeout)
+		defer bgCancel()
+
+		shutdownErr <- errors.Join(err, app.stopBackground(bgCtx))
+	}()
+
 	// Log server start information
 	app.logger.Infoln("server started", "addr:", app.config.addr)
+	app.lifecycle.ready.Store(true)
+
+	// Start the HTTP server, ListenAndServe returns ErrServerClosed as soon as Shutdown is called
+	if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		// the server never ran, the workers still have to stop before main closes the database
+		ctx, cancel := context.WithTimeout(context.Background(), app.config.server.shutdownTimeout)
+		defer cancel()
+
+		return errors.Join(err, app.stopBackground(ctx))
+	}
+
+	if err := <-shutdownErr; err != nil {
+		return err
+	}
 
-	// Start the HTTP server
-	return srv.ListenAndServe()
+	app.logger.Infoln("server stopped", "addr:", app.config.addr)
+	return nil
 }
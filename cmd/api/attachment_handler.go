@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/sirUnchained/udemy-backend-course/internal/blob"
+	"github.com/sirUnchained/udemy-backend-course/internal/media"
+	"github.com/sirUnchained/udemy-backend-course/internal/store"
+)
+
+const (
+	thumbnailSize = 320 // px, the longest side
+	// parts of the form bigger than this go to temp files instead of memory
+	multipartMemory = 8 << 20
+)
+
+// an upload that passed the checks and is ready to be stored
+type checkedImage struct {
+	image     *media.Image
+	data      []byte
+	thumbnail []byte
+}
+
+// UploadAttachments		godoc
+//
+//	@Summary		attach images to a post, only its author or an admin can do it
+//	@Description	jpeg, png or gif, up to 5mb each and 4 per post. the type is sniffed from the content.
+//	@Tags			posts
+//	@Accept			multipart/form-data
+//	@Produce		json
+//	@Param			postid	path		int		true	"Post ID"
+//	@Param			files	formData	file	true	"the images, repeat the field for more than one"
+//	@Success		201		{object}	[]store.Attachment
+//	@Failure		400		{object}	error
+//	@Failure		403		{object}	error
+//	@Failure		404		{object}	error
+//	@Failure		500		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/posts/{postid}/attachments [post]
+func (app *application) uploadAttachmentsHandler(w http.ResponseWriter, r *http.Request) {
+	post := getPostFromCtx(r)
+	user := app.getUserFromCtx(r)
+
+	// every image at its max size, plus some room for the rest of the form
+	r.Body = http.MaxBytesReader(w, r.Body, store.MaxAttachmentsPerPost*media.MaxUploadSize+1<<20)
+	if err := r.ParseMultipartForm(multipartMemory); err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	files := r.MultipartForm.File["files"]
+	if len(files) == 0 {
+		app.badRequestError(w, r, errors.New("send the images in the files field"))
+		return
+	}
+	if len(post.Attachments)+len(files) > store.MaxAttachmentsPerPost {
+		app.badRequestError(w, r, store.ErrTooManyAttachments)
+		return
+	}
+
+	// check every file before we store any of them
+	images := make([]checkedImage, 0, len(files))
+	for _, fh := range files {
+		img, err := checkImage(fh)
+		if err != nil {
+			app.badRequestError(w, r, fmt.Errorf("%s: %w", fh.Filename, err))
+			return
+		}
+		images = append(images, img)
+	}
+
+	ctx := r.Context()
+	attachments := make([]*store.Attachment, 0, len(images))
+	var keys []string
+	for _, img := range images {
+		// a new name for every upload, so the urls can be cached forever
+		base := fmt.Sprintf("posts/%d/%s", post.ID, uuid.NewString())
+		a := &store.Attachment{
+			UserID:       user.ID,
+			BlobKey:      base + img.image.Ext,
+			ThumbnailKey: base + "_thumb.jpg",
+			ContentType:  img.image.ContentType,
+			Size:         int64(len(img.data)),
+			Width:        img.image.Width,
+			Height:       img.image.Height,
+		}
+		if err := app.blobStore.Put(ctx, a.BlobKey, img.data, a.ContentType); err != nil {
+			app.deleteBlobs(keys...)
+			app.internalServerError(w, r, err)
+			return
+		}
+		keys = append(keys, a.BlobKey)
+
+		if err := app.blobStore.Put(ctx, a.ThumbnailKey, img.thumbnail, "image/jpeg"); err != nil {
+			app.deleteBlobs(keys...)
+			app.internalServerError(w, r, err)
+			return
+		}
+		keys = append(keys, a.ThumbnailKey)
+
+		attachments = append(attachments, a)
+	}
+
+	if err := app.store.Attachments.Create(ctx, post.ID, attachments); err != nil {
+		app.deleteBlobs(keys...)
+		switch {
+		case errors.Is(err, store.ErrTooManyAttachments):
+			app.badRequestError(w, r, err)
+		case errors.Is(err, store.ErrNotFound):
+			app.notFoundError(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusCreated, attachments); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// checkImage reads the upload and makes sure it is an image we accept
+func checkImage(fh *multipart.FileHeader) (checkedImage, error) {
+	if fh.Size > media.MaxUploadSize {
+		return checkedImage{}, fmt.Errorf("images can't be bigger than %d bytes", media.MaxUploadSize)
+	}
+
+	f, err := fh.Open()
+	if err != nil {
+		return checkedImage{}, err
+	}
+	defer f.Close()
+
+	// the size in the header comes from the client, don't rely on it
+	data, err := io.ReadAll(io.LimitReader(f, media.MaxUploadSize+1))
+	if err != nil {
+		return checkedImage{}, err
+	}
+	if len(data) > media.MaxUploadSize {
+		return checkedImage{}, fmt.Errorf("images can't be bigger than %d bytes", media.MaxUploadSize)
+	}
+
+	img, err := media.Inspect(data)
+	if err != nil {
+		return checkedImage{}, err
+	}
+
+	thumbnail, err := media.Thumbnail(data, thumbnailSize)
+	if err != nil {
+		return checkedImage{}, fmt.Errorf("broken image: %w", err)
+	}
+
+	return checkedImage{image: img, data: data, thumbnail: thumbnail}, nil
+}
+
+// DeleteAttachment		godoc
+//
+//	@Summary		remove an image from a post, only its author or a moderator can do it
+//	@Tags			posts
+//	@Produce		json
+//	@Param			postid			path		int	true	"Post ID"
+//	@Param			attachmentid	path		int	true	"Attachment ID"
+//	@Success		200				{object}	string
+//	@Failure		400				{object}	error
+//	@Failure		403				{object}	error
+//	@Failure		404				{object}	error
+//	@Failure		500				{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/posts/{postid}/attachments/{attachmentid} [delete]
+func (app *application) deleteAttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "attachmentid"), 10, 64)
+	if err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	post := getPostFromCtx(r)
+	attachment, err := app.store.Attachments.Delete(r.Context(), post.ID, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.notFoundError(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	app.deleteBlobs(attachment.BlobKey, attachment.ThumbnailKey)
+
+	if err := app.jsonResponse(w, http.StatusOK, "attachment deleted."); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// GetMedia		godoc
+//
+//	@Summary		download an uploaded file, the urls of attachments point here with the local blob store
+//	@Tags			media
+//	@Produce		image/jpeg,image/png,image/gif
+//	@Param			key	path		string	true	"blob key"
+//	@Success		200	{file}		file
+//	@Failure		404	{object}	error
+//	@Failure		500	{object}	error
+//	@Router			/media/{key} [get]
+func (app *application) getMediaHandler(w http.ResponseWriter, r *http.Request) {
+	body, contentType, err := app.blobStore.Get(r.Context(), chi.URLParam(r, "*"))
+	if err != nil {
+		switch {
+		case errors.Is(err, blob.ErrNotFound):
+			app.notFoundError(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+	defer body.Close()
+
+	w.Header().Set("Content-Type", contentType)
+	// we sniffed it on upload, browsers must not guess something else
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	// keys are never reused, so what is under one never changes
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.WriteHeader(http.StatusOK)
+
+	if _, err := io.Copy(w, body); err != nil {
+		app.logger.Warnw("error sending media", "path", r.URL.Path, "error", err)
+	}
+}
+
+// deleteBlobs is best effort, a blob we fail to delete is only wasted space
+func (app *application) deleteBlobs(keys ...string) {
+	// the request may be cancelled already, the cleanup has to happen anyway
+	ctx, cancel := context.WithTimeout(context.Background(), store.QueryTimeoutDuration)
+	defer cancel()
+
+	for _, key := range keys {
+		if err := app.blobStore.Delete(ctx, key); err != nil {
+			app.logger.Errorw("error deleting blob", "key", key, "error", err)
+		}
+	}
+}
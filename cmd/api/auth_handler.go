@@ -1,33 +1,66 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
 	"crypto/sha256"
+	"encoding/base64"
 	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"time"
 
 	"github.com/go-chi/chi/v5"
-	"github.com/golang-jwt/jwt"
 	"github.com/google/uuid"
+	"github.com/sirUnchained/udemy-backend-course/internal/auth"
+	"github.com/sirUnchained/udemy-backend-course/internal/mailer"
 	"github.com/sirUnchained/udemy-backend-course/internal/store"
 )
 
 type RegisterUserPayload struct {
-	Username string `json:"username" validate:"required,max=100"`
+	Username string `json:"username" validate:"required,min=3,max=100,username"`
 	Email    string `json:"email" validate:"required,max=255,email"`
 	Password string `json:"password" validate:"required,max=100,min=8"`
 }
 
-type UserWithToken struct {
-	*store.User
-	Token string `json:"token"`
-}
-
 type CreateUserTokenPayload struct {
 	Email    string `json:"email" validate:"required,email,max=255"`
 	Password string `json:"password" validate:"required,min=3,max=72"`
 }
 
+type RefreshTokenPayload struct {
+	RefreshToken string `json:"refresh_token" validate:"required,max=100"`
+}
+
+type LogoutPayload struct {
+	RefreshToken string `json:"refresh_token" validate:"max=100"`
+}
+
+type ForgotPasswordPayload struct {
+	Email string `json:"email" validate:"required,email,max=255"`
+}
+
+// bcrypt only looks at the first 72 bytes, longer passwords would fail to hash
+type ResetPasswordPayload struct {
+	Token    string `json:"token" validate:"required,max=100"`
+	Password string `json:"password" validate:"required,min=8,max=72"`
+}
+
+type ChangePasswordPayload struct {
+	OldPassword string `json:"old_password" validate:"required,max=72"`
+	NewPassword string `json:"new_password" validate:"required,min=8,max=72"`
+}
+
+// TokenPair is what the client gets after login and after every refresh
+type TokenPair struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"` // seconds until the access token expires
+}
+
 // registerUserHandler		godoc
 //
 //	@Summary		register user
@@ -35,8 +68,8 @@ type CreateUserTokenPayload struct {
 //	@Tags			authentication
 //	@Accept			json
 //	@Produce		json
-//	@Param			payload	body	UserWithToken			true	"User credentials"
-//	@Success		200	{object}	store.User	"user registered"
+//	@Param			payload	body	RegisterUserPayload			true	"User credentials"
+//	@Success		201	{object}	store.PrivateUser	"user registered, the activation link is in the email"
 //	@Failure		400	{object}	error
 //	@Failure		500	{object}	error
 //	@Security		ApiKeyAuth
@@ -66,11 +99,10 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 
 	plainToken := uuid.New().String()
 	// hash token for storage but keep the plain token for email
-	hashed := sha256.Sum256([]byte(plainToken))
-	hashToken := hex.EncodeToString(hashed[:])
+	hashedToken := hashToken(plainToken)
 
 	ctx := r.Context()
-	if err := app.store.Users.CreateAndInvite(ctx, newUser, hashToken, app.config.mail.exp); err != nil {
+	if err := app.store.Users.CreateAndInvite(ctx, newUser, hashedToken, app.config.mail.exp); err != nil {
 		switch err {
 		case store.ErrDuplicatedEmail:
 			app.badRequestError(w, r, err)
@@ -82,12 +114,30 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	userWithToken := &UserWithToken{
-		User:  newUser,
-		Token: plainToken,
+	// the frontend page sends the PUT /v1/users/activate/{token} request
+	activationURL := fmt.Sprintf("%s/confirm/%s", app.config.frontendURL, plainToken)
+	vars := struct {
+		Username      string
+		ActivationURL string
+	}{
+		Username:      newUser.UserName,
+		ActivationURL: activationURL,
+	}
+
+	if err := app.mailer.Send(mailer.UserInvitationTemplate, newUser.UserName, newUser.Email, vars); err != nil {
+		app.logger.Errorw("error sending invitation email", "error", err)
+
+		// rollback the user so the email and username can be used again
+		if err := app.store.Users.Delete(ctx, newUser.ID); err != nil {
+			app.logger.Errorw("error deleting user", "error", err)
+		}
+
+		app.internalServerError(w, r, err)
+		return
 	}
 
-	if err := app.jsonResponse(w, http.StatusOK, userWithToken); err != nil {
+	// the token is only in the email, whoever registers has to own the address
+	if err := app.jsonResponse(w, http.StatusCreated, newUser.Private()); err != nil {
 		app.internalServerError(w, r, err)
 		return
 	}
@@ -109,7 +159,8 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Request) {
 	token := chi.URLParam(r, "token")
 
-	if err := app.store.Users.Activate(r.Context(), token); err != nil {
+	userID, err := app.store.Users.Activate(r.Context(), token)
+	if err != nil {
 		switch err {
 		case store.ErrNotFound:
 			app.notFoundError(w, r, err)
@@ -119,6 +170,9 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	// the cached user still says is_active = false
+	app.invalidateUser(r.Context(), userID)
+
 	if err := app.jsonResponse(w, http.StatusOK, nil); err != nil {
 		app.internalServerError(w, r, err)
 		return
@@ -129,12 +183,12 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 // createTokenHandler godoc
 //
 //	@Summary		Creates a token
-//	@Description	Creates a token for a user
+//	@Description	Creates a short lived access token and a refresh token for a user
 //	@Tags			authentication
 //	@Accept			json
 //	@Produce		json
 //	@Param			payload	body		CreateUserTokenPayload	true	"User credentials"
-//	@Success		200		{string}	string					"Token"
+//	@Success		201		{object}	TokenPair				"Tokens"
 //	@Failure		400		{object}	error
 //	@Failure		401		{object}	error
 //	@Failure		500		{object}	error
@@ -166,22 +220,462 @@ func (app *application) createTokenHandler(w http.ResponseWriter, r *http.Reques
 		app.unauthorizedErrorResponse(w, r, err)
 		return
 	}
-	claims := jwt.MapClaims{
-		"sub": user.ID,
-		"exp": time.Now().Add(app.config.auth.token.exp).Unix(),
-		"iat": time.Now().Unix(),
-		"nbf": time.Now().Unix(),
-		"iss": app.config.auth.token.iss,
-		"aud": app.config.auth.token.aud,
+
+	plainRefresh, err := newOpaqueToken()
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	// every login starts a new refresh token family
+	refreshToken := &store.RefreshToken{
+		UserID:    user.ID,
+		TokenHash: hashToken(plainRefresh),
+		FamilyID:  uuid.New().String(),
+		ExpiresAt: time.Now().Add(app.config.auth.token.refreshExp),
+	}
+	if err := app.store.Tokens.CreateRefreshToken(r.Context(), refreshToken); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	accessToken, err := app.generateAccessToken(user)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusCreated, app.newTokenPair(accessToken, plainRefresh)); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// refreshTokenHandler godoc
+//
+//	@Summary		Refreshes a token
+//	@Description	Trades a refresh token for a new access token and a new refresh token, the old refresh token can't be used again
+//	@Tags			authentication
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		RefreshTokenPayload	true	"Refresh token"
+//	@Success		200		{object}	TokenPair			"Tokens"
+//	@Failure		400		{object}	error
+//	@Failure		401		{object}	error
+//	@Failure		500		{object}	error
+//	@Router			/authentication/refresh [post]
+func (app *application) refreshTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var payload RefreshTokenPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	plainRefresh, err := newOpaqueToken()
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+	next := &store.RefreshToken{
+		TokenHash: hashToken(plainRefresh),
+		ExpiresAt: time.Now().Add(app.config.auth.token.refreshExp),
+	}
+	if err := app.store.Tokens.RotateRefreshToken(ctx, hashToken(payload.RefreshToken), next); err != nil {
+		switch {
+		case errors.Is(err, store.ErrTokenReused):
+			app.logger.Warnw("refresh token reused, family revoked", "error", err)
+			app.unauthorizedErrorResponse(w, r, err)
+		case errors.Is(err, store.ErrNotFound), errors.Is(err, store.ErrTokenExpired):
+			app.unauthorizedErrorResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	// don't trust the cache here, the user could have been deactivated
+	user, err := app.store.Users.GetById(ctx, next.UserID)
+	if err != nil {
+		app.unauthorizedErrorResponse(w, r, err)
+		return
+	}
+	if !user.IsActive {
+		app.unauthorizedErrorResponse(w, r, fmt.Errorf("user %d is not active", user.ID))
+		return
+	}
+
+	accessToken, err := app.generateAccessToken(user)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, app.newTokenPair(accessToken, plainRefresh)); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// logoutHandler godoc
+//
+//	@Summary		Logs out
+//	@Description	Revokes the current access token and, if given, the refresh token with everything rotated from it
+//	@Tags			authentication
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		LogoutPayload	false	"Refresh token"
+//	@Success		200		{string}	string			"logged out."
+//	@Failure		400		{object}	error
+//	@Failure		401		{object}	error
+//	@Failure		500		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/authentication/logout [post]
+func (app *application) logoutHandler(w http.ResponseWriter, r *http.Request) {
+	var payload LogoutPayload
+	// the body is optional, no body means only the access token is revoked
+	if err := readJSON(w, r, &payload); err != nil && !errors.Is(err, io.EOF) {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+	user := app.getUserFromCtx(r)
+	claims := getClaimsFromCtx(r)
+
+	if err := app.store.Tokens.RevokeAccessToken(ctx, claims.Id, user.ID, time.Unix(claims.ExpiresAt, 0)); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if payload.RefreshToken != "" {
+		err := app.store.Tokens.RevokeRefreshFamily(ctx, hashToken(payload.RefreshToken))
+		if err != nil && !errors.Is(err, store.ErrNotFound) {
+			app.internalServerError(w, r, err)
+			return
+		}
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, "logged out."); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// forgotPasswordHandler godoc
+//
+//	@Summary		Asks for a password reset
+//	@Description	Emails a single use reset token to the user. the answer is the same whether the email has an account or not
+//	@Tags			authentication
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		ForgotPasswordPayload	true	"Email"
+//	@Success		200		{string}	string					"reset email sent."
+//	@Failure		400		{object}	error
+//	@Failure		500		{object}	error
+//	@Router			/authentication/forgot-password [post]
+func (app *application) forgotPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var payload ForgotPasswordPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	// never tell the client if the email has an account, it would be a way to find out who is a user
+	const message = "if the email belongs to an account, a reset link was sent to it."
+
+	ctx := r.Context()
+	user, err := app.store.Users.GetByEmail(ctx, payload.Email)
+	if err != nil {
+		if !errors.Is(err, store.ErrNotFound) {
+			app.internalServerError(w, r, err)
+			return
+		}
+
+		if err := app.jsonResponse(w, http.StatusOK, message); err != nil {
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	plainToken, err := newOpaqueToken()
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	// saving the token and sending the email happen after the response, so a real account
+	// answers as fast as an unknown email and the timing doesn't give it away either.
+	// a failure can't change the answer anyway, the user just asks again.
+	app.background(func(ctx context.Context) {
+		// same as the invitations, the database only sees the hash
+		exp := app.config.mail.resetExp
+		if err := app.store.Users.CreatePasswordReset(ctx, user.ID, hashToken(plainToken), exp); err != nil {
+			app.logger.Errorw("error saving password reset token", "user_id", user.ID, "error", err)
+			return
+		}
+
+		vars := struct {
+			Username  string
+			ResetURL  string
+			ExpiresIn string
+		}{
+			Username: user.UserName,
+			// the frontend page asks for the new password and sends it to /v1/authentication/reset-password
+			ResetURL:  fmt.Sprintf("%s/reset-password/%s", app.config.frontendURL, plainToken),
+			ExpiresIn: fmt.Sprintf("%.0f minutes", exp.Minutes()),
+		}
+
+		if err := app.mailer.Send(mailer.PasswordResetTemplate, user.UserName, user.Email, vars); err != nil {
+			app.logger.Errorw("error sending password reset email", "user_id", user.ID, "error", err)
+		}
+	})
+
+	if err := app.jsonResponse(w, http.StatusOK, message); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// resetPasswordHandler godoc
+//
+//	@Summary		Resets the password
+//	@Description	Sets a new password with the token from the reset email, the user is logged out everywhere
+//	@Tags			authentication
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		ResetPasswordPayload	true	"Reset token and the new password"
+//	@Success		200		{string}	string					"password reset."
+//	@Failure		400		{object}	error
+//	@Failure		500		{object}	error
+//	@Router			/authentication/reset-password [post]
+func (app *application) resetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var payload ResetPasswordPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	user := &store.User{}
+	if err := user.Password.Set(payload.Password); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+	if err := app.store.Users.ResetPassword(ctx, hashToken(payload.Token), user); err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.badRequestError(w, r, errors.New("the reset token is invalid or expired"))
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := app.endSessions(ctx, user.ID); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, "password reset."); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// changePasswordHandler godoc
+//
+//	@Summary		Changes the password
+//	@Description	Needs the old password. every session of the user ends, the caller gets new tokens to stay logged in
+//	@Tags			authentication
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		ChangePasswordPayload	true	"Old and new password"
+//	@Success		200		{object}	TokenPair				"Tokens"
+//	@Failure		400		{object}	error
+//	@Failure		401		{object}	error
+//	@Failure		500		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/authentication/change-password [post]
+func (app *application) changePasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var payload ChangePasswordPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	if payload.OldPassword == payload.NewPassword {
+		app.badRequestError(w, r, errors.New("the new password must be different from the old one"))
+		return
+	}
+
+	ctx := r.Context()
+
+	// the user in the context comes from the cache, it has no password hash
+	user, err := app.store.Users.GetByEmail(ctx, app.getUserFromCtx(r).Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.unauthorizedErrorResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := user.Password.Compare(payload.OldPassword); err != nil {
+		app.unauthorizedErrorResponse(w, r, err)
+		return
+	}
+
+	if err := user.Password.Set(payload.NewPassword); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.store.Users.UpdatePassword(ctx, user); err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.unauthorizedErrorResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := app.endSessions(ctx, user.ID); err != nil {
+		app.internalServerError(w, r, err)
+		return
 	}
 
-	token, err := app.authenticator.GenerateToken(claims)
+	// the token of this request is dead now too, start a new session for the caller
+	plainRefresh, err := newOpaqueToken()
 	if err != nil {
 		app.internalServerError(w, r, err)
 		return
 	}
 
-	if err := app.jsonResponse(w, http.StatusCreated, token); err != nil {
+	refreshToken := &store.RefreshToken{
+		UserID:    user.ID,
+		TokenHash: hashToken(plainRefresh),
+		FamilyID:  uuid.New().String(),
+		ExpiresAt: time.Now().Add(app.config.auth.token.refreshExp),
+	}
+	if err := app.store.Tokens.CreateRefreshToken(ctx, refreshToken); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	accessToken, err := app.generateAccessToken(user)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, app.newTokenPair(accessToken, plainRefresh)); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// endSessions logs the user out everywhere after a password change. refresh tokens are
+// revoked here, older access tokens are rejected by the auth middleware as soon as it
+// sees the new token version, so the cached user has to go.
+func (app *application) endSessions(ctx context.Context, userID int64) error {
+	if err := app.store.Tokens.RevokeAllForUser(ctx, userID); err != nil {
+		return err
+	}
+
+	app.invalidateUser(ctx, userID)
+	return nil
+}
+
+func (app *application) generateAccessToken(user *store.User) (string, error) {
+	cfg := app.config.auth.token
+	claims := auth.NewClaims(user.ID, user.TokenVersion, uuid.New().String(), cfg.aud, cfg.iss, time.Now(), cfg.exp)
+
+	return app.authenticator.GenerateToken(claims)
+}
+
+func (app *application) newTokenPair(accessToken, refreshToken string) TokenPair {
+	return TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(app.config.auth.token.exp.Seconds()),
+	}
+}
+
+// tokenCleanupWorker removes expired refresh tokens, revoked access tokens and reset tokens every hour
+func (app *application) tokenCleanupWorker(ctx context.Context) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := app.store.Tokens.DeleteExpired(ctx); err != nil {
+				app.logger.Errorw("error deleting expired tokens", "error", err)
+			}
+		}
+	}
+}
+
+// hashToken is how we store tokens we hand out, only the sha256 of them
+func hashToken(plain string) string {
+	hashed := sha256.Sum256([]byte(plain))
+	return hex.EncodeToString(hashed[:])
+}
+
+// newOpaqueToken returns 32 random bytes, url safe
+func newOpaqueToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// jwksHandler godoc
+//
+//	@Summary		Public signing keys
+//	@Description	The JSON Web Key Set other services use to verify our tokens, empty when tokens are signed with a shared secret
+//	@Tags			authentication
+//	@Produce		json
+//	@Success		200	{object}	auth.JWKSet
+//	@Router			/.well-known/jwks.json [get]
+func (app *application) jwksHandler(w http.ResponseWriter, r *http.Request) {
+	set := auth.JWKSet{Keys: []auth.JWK{}}
+	if provider, ok := app.authenticator.(auth.JWKSProvider); ok {
+		set = provider.JWKS()
+	}
+
+	// verifiers may cache it, but not for too long or they miss new keys
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	if err := writeJSON(w, http.StatusOK, set); err != nil {
 		app.internalServerError(w, r, err)
 	}
 }
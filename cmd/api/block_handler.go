@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+// BlockUser		godoc
+//
+//	@Summary		block a user
+//	@Description	removes the follows between you two, they can't follow you or comment on your posts and you don't see each other in feeds and comments
+//	@Tags			user
+//	@Produce		json
+//	@Param			userid	path		int	true	"User ID"
+//	@Success		200		{object}	string
+//	@Failure		400		{object}	error
+//	@Failure		404		{object}	error
+//	@Failure		500		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/users/{userid}/block [put]
+func (app *application) blockUserHandler(w http.ResponseWriter, r *http.Request) {
+	app.changeUserRelation(w, r, app.store.Blocks.Block, "user blocked.")
+}
+
+// UnblockUser		godoc
+//
+//	@Summary		unblock a user
+//	@Tags			user
+//	@Produce		json
+//	@Param			userid	path		int	true	"User ID"
+//	@Success		200		{object}	string
+//	@Failure		400		{object}	error
+//	@Failure		404		{object}	error
+//	@Failure		500		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/users/{userid}/block [delete]
+func (app *application) unblockUserHandler(w http.ResponseWriter, r *http.Request) {
+	app.changeUserRelation(w, r, app.store.Blocks.Unblock, "user unblocked.")
+}
+
+// MuteUser		godoc
+//
+//	@Summary		mute a user
+//	@Description	their posts and comments are hidden from you, they don't know about it
+//	@Tags			user
+//	@Produce		json
+//	@Param			userid	path		int	true	"User ID"
+//	@Success		200		{object}	string
+//	@Failure		400		{object}	error
+//	@Failure		404		{object}	error
+//	@Failure		500		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/users/{userid}/mute [put]
+func (app *application) muteUserHandler(w http.ResponseWriter, r *http.Request) {
+	app.changeUserRelation(w, r, app.store.Blocks.Mute, "user muted.")
+}
+
+// UnmuteUser		godoc
+//
+//	@Summary		unmute a user
+//	@Tags			user
+//	@Produce		json
+//	@Param			userid	path		int	true	"User ID"
+//	@Success		200		{object}	string
+//	@Failure		400		{object}	error
+//	@Failure		404		{object}	error
+//	@Failure		500		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/users/{userid}/mute [delete]
+func (app *application) unmuteUserHandler(w http.ResponseWriter, r *http.Request) {
+	app.changeUserRelation(w, r, app.store.Blocks.Unmute, "user unmuted.")
+}
+
+// changeUserRelation runs change between the current user and the user in the url
+func (app *application) changeUserRelation(w http.ResponseWriter, r *http.Request, change func(ctx context.Context, userID, otherID int64) error, message string) {
+	user := app.getUserFromCtx(r)
+	target := getTargetUserFromCtx(r)
+
+	if user.ID == target.ID {
+		app.badRequestError(w, r, errors.New("you can't do that to yourself"))
+		return
+	}
+
+	if err := change(r.Context(), user.ID, target.ID); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, message); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
@@ -1,19 +1,88 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"net/http"
+	"strconv"
 
+	"github.com/go-chi/chi/v5"
+	"github.com/sirUnchained/udemy-backend-course/internal/extract"
 	"github.com/sirUnchained/udemy-backend-course/internal/store"
 )
 
-type commentPayload struct {
-	UserID  int64  `json:"user_id" validate:"required,numeric"`
-	PostID  int64  `json:"post_id" validate:"required,numeric"`
+type CreateCommentPayload struct {
+	ParentID *int64 `json:"parent_id" validate:"omitempty,gte=1"`
+	Content  string `json:"content" validate:"required,max=512"`
+}
+
+type UpdateCommentPayload struct {
 	Content string `json:"content" validate:"required,max=512"`
 }
 
+type commentKey string
+
+const commentCtx commentKey = "COMMENT"
+
+// ListComments		godoc
+//
+//	@Summary		list the comments of a post
+//	@Description	oldest first, replies have a parent_id so the thread can be rebuilt on the client
+//	@Tags			comments
+//	@Produce		json
+//	@Param			postid	path		int		true	"Post ID"
+//	@Param			limit	query		int		false	"page size, 1 to 50"
+//	@Param			cursor	query		string	false	"next_cursor of the previous page"
+//	@Success		200		{object}	[]store.Comment
+//	@Failure		400		{object}	error
+//	@Failure		404		{object}	error
+//	@Failure		500		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/posts/{postid}/comments [get]
+func (app *application) listCommentsHandler(w http.ResponseWriter, r *http.Request) {
+	post := getPostFromCtx(r)
+
+	q, err := store.PaginatedQuery{Limit: 20}.Parse(r)
+	if err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(q); err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	user := app.getUserFromCtx(r)
+
+	page, err := app.store.Comments.ListByPost(r.Context(), post.ID, user.ID, q)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.paginatedJSONResponse(w, http.StatusOK, page.Comments, page.NextCursor, ""); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// CreateComment		godoc
+//
+//	@Summary		comment on a post or reply to a comment
+//	@Tags			comments
+//	@Accept			json
+//	@Produce		json
+//	@Param			postid	path		int						true	"Post ID"
+//	@Param			payload	body		CreateCommentPayload	true	"comment"
+//	@Success		201		{object}	store.Comment
+//	@Failure		400		{object}	error
+//	@Failure		403		{object}	error	"the author of the post blocked you"
+//	@Failure		404		{object}	error
+//	@Failure		500		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/posts/{postid}/comments [post]
 func (app *application) createCommentHandler(w http.ResponseWriter, r *http.Request) {
-	var payload commentPayload
+	var payload CreateCommentPayload
 	if err := readJSON(w, r, &payload); err != nil {
 		app.badRequestError(w, r, err)
 		return
@@ -24,20 +93,149 @@ func (app *application) createCommentHandler(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
+	user := app.getUserFromCtx(r)
+	post := getPostFromCtx(r)
+
 	comment := &store.Comment{
-		UserID:  payload.UserID,
-		PostID:  payload.PostID,
-		Content: payload.Content,
+		UserID:   user.ID,
+		PostID:   post.ID,
+		ParentID: payload.ParentID,
+		Content:  payload.Content,
+		User:     store.Author{ID: user.ID, UserName: user.UserName},
+		Mentions: extract.Mentions(payload.Content),
 	}
 
 	ctx := r.Context()
 	if err := app.store.Comments.Create(ctx, comment); err != nil {
+		switch {
+		case errors.Is(err, store.ErrInvalidParent), errors.Is(err, store.ErrCommentTooDeep):
+			app.badRequestError(w, r, err)
+		case errors.Is(err, store.ErrBlocked):
+			app.forbiddenError(w, r)
+		case errors.Is(err, store.ErrNotFound):
+			app.notFoundError(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusCreated, comment); err != nil {
 		app.internalServerError(w, r, err)
 		return
 	}
+}
+
+// UpdateComment		godoc
+//
+//	@Summary		edit a comment, only its author or an admin can do it
+//	@Tags			comments
+//	@Accept			json
+//	@Produce		json
+//	@Param			postid		path		int						true	"Post ID"
+//	@Param			commentid	path		int						true	"Comment ID"
+//	@Param			payload		body		UpdateCommentPayload	true	"new content"
+//	@Success		200			{object}	store.Comment
+//	@Failure		400			{object}	error
+//	@Failure		403			{object}	error
+//	@Failure		404			{object}	error
+//	@Failure		500			{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/posts/{postid}/comments/{commentid} [patch]
+func (app *application) updateCommentHandler(w http.ResponseWriter, r *http.Request) {
+	comment := getCommentFromCtx(r)
+
+	var payload UpdateCommentPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	comment.Content = payload.Content
+
+	if err := app.store.Comments.Update(r.Context(), comment); err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.notFoundError(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
 
-	if err := writeJSON(w, http.StatusOK, "comment added."); err != nil {
+	if err := app.jsonResponse(w, http.StatusOK, comment); err != nil {
 		app.internalServerError(w, r, err)
+	}
+}
+
+// DeleteComment		godoc
+//
+//	@Summary		delete a comment, its replies stay in the thread
+//	@Tags			comments
+//	@Produce		json
+//	@Param			postid		path		int	true	"Post ID"
+//	@Param			commentid	path		int	true	"Comment ID"
+//	@Success		200			{object}	string
+//	@Failure		403			{object}	error
+//	@Failure		404			{object}	error
+//	@Failure		500			{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/posts/{postid}/comments/{commentid} [delete]
+func (app *application) deleteCommentHandler(w http.ResponseWriter, r *http.Request) {
+	comment := getCommentFromCtx(r)
+
+	if err := app.store.Comments.SoftDelete(r.Context(), comment.ID); err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.notFoundError(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
 		return
 	}
+
+	if err := app.jsonResponse(w, http.StatusOK, "comment is now deleted"); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// commentContextMiddleware loads the comment, it must belong to the post in the url
+func (app *application) commentContextMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(chi.URLParam(r, "commentid"), 10, 64)
+		if err != nil {
+			app.badRequestError(w, r, err)
+			return
+		}
+
+		ctx := r.Context()
+		comment, err := app.store.Comments.GetById(ctx, id)
+		if err != nil {
+			switch {
+			case errors.Is(err, store.ErrNotFound):
+				app.notFoundError(w, r, err)
+			default:
+				app.internalServerError(w, r, err)
+			}
+			return
+		}
+
+		if comment.PostID != getPostFromCtx(r).ID {
+			app.notFoundError(w, r, store.ErrNotFound)
+			return
+		}
+
+		ctx = context.WithValue(ctx, commentCtx, comment)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func getCommentFromCtx(r *http.Request) *store.Comment {
+	comment, _ := r.Context().Value(commentCtx).(*store.Comment)
+	return comment
 }
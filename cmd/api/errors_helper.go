@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"net/http"
 )
 
@@ -19,6 +20,11 @@ func (app *application) conflictRequestError(w http.ResponseWriter, r *http.Requ
 	writeJSONError(w, http.StatusConflict, err.Error())
 }
 
+func (app *application) forbiddenError(w http.ResponseWriter, r *http.Request) {
+	app.logger.Warnf("forbidden error: %s path: %s\n", r.Method, r.URL.Path)
+	writeJSONError(w, http.StatusForbidden, "forbidden")
+}
+
 func (app *application) notFoundError(w http.ResponseWriter, r *http.Request, err error) {
 	app.logger.Warnf("404 error: %s path: %s error: %s\n", r.Method, r.URL.Path, err.Error())
 	writeJSONError(w, http.StatusNotFound, "the record not found.")
@@ -31,7 +37,20 @@ func (app *application) unauthorizedBasicErrorResponse(w http.ResponseWriter, r
 }
 
 func (app *application) unauthorizedErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
-	app.logger.Warnf("unauthorized (basic) error: %s path: %s error: %s\n", r.Method, r.URL.Path, err.Error())
-	w.Header().Set("WWW-Authenticate", `Basic realm="restricted", charset="UTF-8"`)
-	writeJSONError(w, http.StatusNotFound, "unauthorized")
+	app.logger.Warnf("unauthorized error: %s path: %s error: %s\n", r.Method, r.URL.Path, err.Error())
+	w.Header().Set("WWW-Authenticate", `Bearer`)
+	writeJSONError(w, http.StatusUnauthorized, "unauthorized")
+}
+
+// invalidTokenError follows rfc 6750, description is what the client gets to see
+func (app *application) invalidTokenError(w http.ResponseWriter, r *http.Request, err error, description string) {
+	app.logger.Warnf("invalid token error: %s path: %s error: %s\n", r.Method, r.URL.Path, err.Error())
+	w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error="invalid_token", error_description=%q`, description))
+	writeJSONError(w, http.StatusUnauthorized, description)
+}
+
+func (app *application) rateLimitExceededError(w http.ResponseWriter, r *http.Request, retryAfter string) {
+	app.logger.Warnf("rate limit exceeded: %s path: %s\n", r.Method, r.URL.Path)
+	w.Header().Set("Retry-After", retryAfter)
+	writeJSONError(w, http.StatusTooManyRequests, "rate limit exceeded, retry after: "+retryAfter+"s")
 }
@@ -6,12 +6,33 @@ import (
 	"github.com/sirUnchained/udemy-backend-course/internal/store"
 )
 
+// GetUserFeed		godoc
+//
+//	@Summary		fetch the feed of the current user
+//	@Description	posts of the user and the users they follow, pass next_cursor or prev_cursor back as cursor to move between pages
+//	@Tags			feed
+//	@Accept			json
+//	@Produce		json
+//	@Param			limit	query		int		false	"page size, 1 to 20"
+//	@Param			sort	query		string	false	"asc or desc"
+//	@Param			mode	query		string	false	"latest (default) or top, top ranks recent posts by engagement and ignores sort"
+//	@Param			tags	query		string	false	"comma separated tags"
+//	@Param			search	query		string	false	"search in title and content"
+//	@Param			since	query		string	false	"only posts created after this time"
+//	@Param			until	query		string	false	"only posts created before this time"
+//	@Param			cursor	query		string	false	"cursor from a previous page"
+//	@Success		200		{object}	[]store.PostWithMetadata
+//	@Failure		400		{object}	error
+//	@Failure		401		{object}	error
+//	@Failure		500		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/users/feed [get]
 func (app *application) getUserFeedHandler(w http.ResponseWriter, r *http.Request) {
 
 	fq := store.PaginatedFeedQuery{
-		Limit:  20,
-		Offset: 0,
-		Sort:   "desc",
+		Limit: 20,
+		Sort:  "desc",
+		Mode:  store.FeedModeLatest,
 	}
 
 	fq, err := fq.Parse(r)
@@ -25,17 +46,16 @@ func (app *application) getUserFeedHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	// todo: get userid from somewhere else
-	userid := int64(1)
+	user := app.getUserFromCtx(r)
 
 	ctx := r.Context()
-	feed, err := app.store.Posts.GetUserFeed(ctx, userid, fq)
+	page, err := app.store.Posts.GetUserFeed(ctx, user.ID, fq)
 	if err != nil {
 		app.internalServerError(w, r, err)
 		return
 	}
 
-	if err := app.jsonResponse(w, http.StatusOK, feed); err != nil {
+	if err := app.paginatedJSONResponse(w, http.StatusOK, page.Posts, page.NextCursor, page.PrevCursor); err != nil {
 		app.internalServerError(w, r, err)
 		return
 	}
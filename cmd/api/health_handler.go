@@ -2,9 +2,21 @@ package main
 
 import "net/http"
 
+// healthCheckHandler reports "draining" with a 503 while the server shuts down
+// so load balancers stop sending us new requests
 func (app *application) healthCheckHandler(w http.ResponseWriter, r *http.Request) {
+	data := map[string]string{
+		"status":  "ok",
+		"version": VERSION,
+	}
+
+	status := http.StatusOK
+	if !app.lifecycle.ready.Load() {
+		data["status"] = "draining"
+		status = http.StatusServiceUnavailable
+	}
 
-	if err := writeJSONError(w, 404, "404"); err != nil {
+	if err := app.jsonResponse(w, status, data); err != nil {
 		app.internalServerError(w, r, err)
 	}
 }
@@ -3,6 +3,7 @@ package main
 import (
 	"encoding/json"
 	"net/http"
+	"regexp"
 
 	"github.com/go-playground/validator/v10"
 )
@@ -12,6 +13,13 @@ var Validate *validator.Validate
 func init() {
 	// it is good to pass 'WithRequiredStructEnabled' function
 	Validate = validator.New(validator.WithRequiredStructEnabled())
+
+	// the characters a mention can have, so every username can be mentioned.
+	// no "." or "-" at the end, a mention drops those because they end the sentence ("thanks @bob.")
+	usernamePattern := regexp.MustCompile(`^[\p{L}\p{N}_.\-]*[\p{L}\p{N}_]$`)
+	Validate.RegisterValidation("username", func(fl validator.FieldLevel) bool {
+		return usernamePattern.MatchString(fl.Field().String())
+	})
 }
 
 // writeJSON writes a JSON response with the given status code and data
@@ -54,3 +62,13 @@ func (app *application) jsonResponse(w http.ResponseWriter, status int, data any
 	}
 	return writeJSON(w, status, envlope{Data: data})
 }
+
+// paginatedJSONResponse is jsonResponse plus the cursors of the next and previous pages
+func (app *application) paginatedJSONResponse(w http.ResponseWriter, status int, data any, next, prev string) error {
+	type envlope struct {
+		Data       any    `json:"data"`
+		NextCursor string `json:"next_cursor,omitempty"`
+		PrevCursor string `json:"prev_cursor,omitempty"`
+	}
+	return writeJSON(w, status, envlope{Data: data, NextCursor: next, PrevCursor: prev})
+}
@@ -0,0 +1,26 @@
+package main
+
+import "testing"
+
+func TestUsernameValidator(t *testing.T) {
+	tests := map[string]bool{
+		"bob":       true,
+		"Bob_2":     true,
+		"jane.doe":  true,
+		"jane-doe_": true,
+		"café":      true,
+		"b":         true,
+		"bob.":      false, // "@bob." would mention "bob"
+		"bob-":      false,
+		"bob space": false,
+		"bob@home":  false,
+		"":          false,
+	}
+
+	for username, valid := range tests {
+		err := Validate.Var(username, "username")
+		if (err == nil) != valid {
+			t.Errorf("%q valid = %v, want %v", username, err == nil, valid)
+		}
+	}
+}
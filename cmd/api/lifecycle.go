@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// lifecycle keeps track of the server state and of everything that runs in
+// the background, so we can stop them in order when the server shuts down
+type lifecycle struct {
+	// ready is false before the server starts and while it is draining
+	ready atomic.Bool
+	wg    sync.WaitGroup
+	// ctx is cancelled when the server starts shutting down,
+	// long running workers must return when it is done
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func newLifecycle() *lifecycle {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &lifecycle{ctx: ctx, cancel: cancel}
+}
+
+// background runs fn in its own goroutine, shutdown waits for it to return
+func (app *application) background(fn func(ctx context.Context)) {
+	app.lifecycle.wg.Add(1)
+
+	go func() {
+		defer app.lifecycle.wg.Done()
+
+		// a panic in a background job must not kill the whole server
+		defer func() {
+			if err := recover(); err != nil {
+				app.logger.Errorw("background job panicked", "error", fmt.Sprint(err))
+			}
+		}()
+
+		fn(app.lifecycle.ctx)
+	}()
+}
+
+// stopBackground tells the workers to stop and waits until all of them returned
+func (app *application) stopBackground(ctx context.Context) error {
+	app.lifecycle.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		app.lifecycle.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("background workers did not stop in time: %w", ctx.Err())
+	}
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/sirUnchained/udemy-backend-course/internal/chat"
+	"github.com/sirUnchained/udemy-backend-course/internal/store"
+)
+
+// keep in sync with the validate tag of SendMessagePayload
+const maxMessageLength = 1000
+
+var (
+	errEmptyMessage   = errors.New("message is empty")
+	errMessageTooLong = fmt.Errorf("message is longer than %d characters", maxMessageLength)
+	errMessageSelf    = errors.New("you can't message yourself")
+)
+
+type conversationKey string
+
+const conversationCtx conversationKey = "CONVERSATION"
+
+type SendMessagePayload struct {
+	Content string `json:"content" validate:"required,max=1000"`
+}
+
+type MarkConversationReadPayload struct {
+	MessageID int64 `json:"message_id" validate:"required,gte=1"`
+}
+
+// Chat		godoc
+//
+//	@Summary		websocket for direct messages and read receipts
+//	@Description	send {"type":"message","to":<user id>,"content":"..."} or {"type":"read","conversation_id":1,"message_id":2},
+//	@Description	you get "message", "read" and "error" frames back. "ref" is echoed back on the answer to your frame.
+//	@Tags			messages
+//	@Param			access_token	query	string	false	"access token, for clients that can't set the Authorization header"
+//	@Success		101
+//	@Failure		400	{object}	error
+//	@Failure		401	{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/chat [get]
+func (app *application) chatHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.getUserFromCtx(r)
+
+	// the upgrader writes its own error response
+	if err := app.chat.Serve(w, r, user.ID, app.handleChatFrame); err != nil {
+		app.logger.Warnw("websocket upgrade failed", "user_id", user.ID, "error", err)
+	}
+}
+
+func (app *application) handleChatFrame(ctx context.Context, c *chat.Conn, f chat.Frame) {
+	switch f.Type {
+	case chat.FrameMessage:
+		message, err := app.sendMessage(ctx, c.UserID(), f.To, f.Content, c)
+		if err != nil {
+			c.Send(chat.Frame{Type: chat.FrameError, Ref: f.Ref, Error: app.chatError(err)})
+			return
+		}
+		c.Send(chat.Frame{Type: chat.FrameMessage, Ref: f.Ref, Message: message})
+
+	case chat.FrameRead:
+		receipt, err := app.markConversationRead(ctx, f.ConversationID, c.UserID(), f.MessageID, c)
+		if err != nil {
+			c.Send(chat.Frame{Type: chat.FrameError, Ref: f.Ref, Error: app.chatError(err)})
+			return
+		}
+		c.Send(chat.Frame{Type: chat.FrameRead, Ref: f.Ref, Receipt: receipt})
+
+	default:
+		c.Send(chat.Frame{Type: chat.FrameError, Ref: f.Ref, Error: fmt.Sprintf("unknown frame type %q", f.Type)})
+	}
+}
+
+// chatError is what the client of a websocket sees of err
+func (app *application) chatError(err error) string {
+	switch {
+	case errors.Is(err, errEmptyMessage), errors.Is(err, errMessageTooLong), errors.Is(err, errMessageSelf):
+		return err.Error()
+	case errors.Is(err, store.ErrBlocked):
+		return "you can't message this user"
+	case errors.Is(err, store.ErrNotFound):
+		return "not found"
+	default:
+		app.logger.Errorw("chat error", "error", err)
+		return "the server encountered a problem"
+	}
+}
+
+// sendMessage stores the message and delivers it to the sockets of both users,
+// from is the socket it came from (if any), it gets the message as the answer instead
+func (app *application) sendMessage(ctx context.Context, senderID, recipientID int64, content string, from *chat.Conn) (*store.Message, error) {
+	content = strings.TrimSpace(content)
+	switch {
+	case content == "":
+		return nil, errEmptyMessage
+	case len([]rune(content)) > maxMessageLength:
+		return nil, errMessageTooLong
+	case senderID == recipientID:
+		return nil, errMessageSelf
+	}
+
+	message, err := app.store.Messages.Send(ctx, senderID, recipientID, content)
+	if err != nil {
+		return nil, err
+	}
+
+	frame := chat.Frame{Type: chat.FrameMessage, Message: message}
+	app.chat.Send(recipientID, frame, nil)
+	app.chat.Send(senderID, frame, from)
+
+	return message, nil
+}
+
+// markConversationRead marks the conversation read and sends the receipt to the sender,
+// unless one of them blocked the other since
+func (app *application) markConversationRead(ctx context.Context, conversationID, readerID, upToID int64, from *chat.Conn) (*store.ReadReceipt, error) {
+	receipt, err := app.store.Messages.MarkRead(ctx, conversationID, readerID, upToID)
+	if err != nil {
+		return nil, err
+	}
+
+	// nothing new was read
+	if receipt.MessageID == 0 {
+		return receipt, nil
+	}
+
+	frame := chat.Frame{Type: chat.FrameRead, Receipt: receipt}
+	app.chat.Send(readerID, frame, from)
+
+	blocked, err := app.store.Blocks.IsBlocked(ctx, readerID, receipt.SenderID)
+	if err != nil {
+		return nil, err
+	}
+	if !blocked {
+		app.chat.Send(receipt.SenderID, frame, nil)
+	}
+
+	return receipt, nil
+}
+
+// SendMessage		godoc
+//
+//	@Summary		send a direct message to a user
+//	@Tags			messages
+//	@Accept			json
+//	@Produce		json
+//	@Param			userid	path		int					true	"User ID"
+//	@Param			payload	body		SendMessagePayload	true	"message"
+//	@Success		201		{object}	store.Message
+//	@Failure		400		{object}	error
+//	@Failure		403		{object}	error	"one of you blocked the other"
+//	@Failure		404		{object}	error
+//	@Failure		500		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/users/{userid}/messages [post]
+func (app *application) sendMessageHandler(w http.ResponseWriter, r *http.Request) {
+	var payload SendMessagePayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	user := app.getUserFromCtx(r)
+	target := getTargetUserFromCtx(r)
+
+	message, err := app.sendMessage(r.Context(), user.ID, target.ID, payload.Content, nil)
+	if err != nil {
+		switch {
+		case errors.Is(err, errEmptyMessage), errors.Is(err, errMessageTooLong), errors.Is(err, errMessageSelf):
+			app.badRequestError(w, r, err)
+		case errors.Is(err, store.ErrBlocked):
+			app.forbiddenError(w, r)
+		case errors.Is(err, store.ErrNotFound):
+			app.notFoundError(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusCreated, message); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// ListConversations		godoc
+//
+//	@Summary		list your conversations, the most recently active first
+//	@Tags			messages
+//	@Produce		json
+//	@Param			limit	query		int		false	"page size, 1 to 50"
+//	@Param			cursor	query		string	false	"next_cursor of the previous page"
+//	@Success		200		{object}	[]store.Conversation
+//	@Failure		400		{object}	error
+//	@Failure		500		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/conversations [get]
+func (app *application) listConversationsHandler(w http.ResponseWriter, r *http.Request) {
+	q, err := store.PaginatedQuery{Limit: 20}.Parse(r)
+	if err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(q); err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	user := app.getUserFromCtx(r)
+	page, err := app.store.Messages.ListConversations(r.Context(), user.ID, q)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.paginatedJSONResponse(w, http.StatusOK, page.Conversations, page.NextCursor, ""); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// ListMessages		godoc
+//
+//	@Summary		page back through the messages of a conversation, newest first
+//	@Tags			messages
+//	@Produce		json
+//	@Param			conversationid	path		int		true	"Conversation ID"
+//	@Param			limit			query		int		false	"page size, 1 to 50"
+//	@Param			cursor			query		string	false	"next_cursor of the previous page"
+//	@Success		200				{object}	[]store.Message
+//	@Failure		400				{object}	error
+//	@Failure		404				{object}	error
+//	@Failure		500				{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/conversations/{conversationid}/messages [get]
+func (app *application) listMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	q, err := store.PaginatedQuery{Limit: 50}.Parse(r)
+	if err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(q); err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	conversation := getConversationFromCtx(r)
+	page, err := app.store.Messages.ListMessages(r.Context(), conversation.ID, q)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.paginatedJSONResponse(w, http.StatusOK, page.Messages, page.NextCursor, ""); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// MarkConversationRead		godoc
+//
+//	@Summary		mark the messages you received in a conversation read, up to message_id
+//	@Tags			messages
+//	@Accept			json
+//	@Produce		json
+//	@Param			conversationid	path		int							true	"Conversation ID"
+//	@Param			payload			body		MarkConversationReadPayload	true	"last message you read"
+//	@Success		200				{object}	store.ReadReceipt
+//	@Failure		400				{object}	error
+//	@Failure		404				{object}	error
+//	@Failure		500				{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/conversations/{conversationid}/read [post]
+func (app *application) markConversationReadHandler(w http.ResponseWriter, r *http.Request) {
+	var payload MarkConversationReadPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	user := app.getUserFromCtx(r)
+	conversation := getConversationFromCtx(r)
+
+	receipt, err := app.markConversationRead(r.Context(), conversation.ID, user.ID, payload.MessageID, nil)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.notFoundError(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, receipt); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// conversationContextMiddleware loads the conversation, only its two users can see it
+func (app *application) conversationContextMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(chi.URLParam(r, "conversationid"), 10, 64)
+		if err != nil {
+			app.badRequestError(w, r, err)
+			return
+		}
+
+		ctx := r.Context()
+		user := app.getUserFromCtx(r)
+		conversation, err := app.store.Messages.GetConversation(ctx, id, user.ID)
+		if err != nil {
+			switch {
+			case errors.Is(err, store.ErrNotFound):
+				app.notFoundError(w, r, err)
+			default:
+				app.internalServerError(w, r, err)
+			}
+			return
+		}
+
+		ctx = context.WithValue(ctx, conversationCtx, conversation)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func getConversationFromCtx(r *http.Request) *store.Conversation {
+	conversation, _ := r.Context().Value(conversationCtx).(*store.Conversation)
+	return conversation
+}
@@ -3,12 +3,13 @@ package main
 import (
 	"context"
 	"encoding/base64"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
-	"strconv"
 	"strings"
 
-	"github.com/golang-jwt/jwt"
+	"github.com/sirUnchained/udemy-backend-course/internal/auth"
 	"github.com/sirUnchained/udemy-backend-course/internal/store"
 )
 
@@ -52,71 +53,295 @@ func (app *application) BasicAuthMiddleware() func(http.Handler) http.Handler {
 }
 
 func (app *application) AuthTokenMiddleware(next http.Handler) http.Handler {
+	return app.authenticate(next, false)
+}
+
+// StreamAuthMiddleware is AuthTokenMiddleware that also takes the token from the
+// access_token query param, browsers can't set headers on websockets and EventSource
+func (app *application) StreamAuthMiddleware(next http.Handler) http.Handler {
+	return app.authenticate(next, true)
+}
+
+func (app *application) authenticate(next http.Handler, allowQuery bool) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
-			app.unauthorizedErrorResponse(w, r, fmt.Errorf("authorization header is missing"))
-			return
-		}
+		queryToken, _ := r.Context().Value(queryTokenCtx).(string)
 
-		parts := strings.Split(authHeader, " ")
-		if len(parts) != 2 || parts[0] != "Bearer" {
-			app.unauthorizedErrorResponse(w, r, fmt.Errorf("authorization header is malformed"))
+		var token string
+		switch {
+		case authHeader != "":
+			parts := strings.Split(authHeader, " ")
+			if len(parts) != 2 || parts[0] != "Bearer" {
+				app.unauthorizedErrorResponse(w, r, fmt.Errorf("authorization header is malformed"))
+				return
+			}
+			token = parts[1]
+		case allowQuery && queryToken != "":
+			token = queryToken
+		default:
+			app.unauthorizedErrorResponse(w, r, fmt.Errorf("authorization header is missing"))
 			return
 		}
 
-		token := parts[1]
 		jwtToken, err := app.authenticator.ValidateToken(token)
 		if err != nil {
-			app.unauthorizedErrorResponse(w, r, err)
+			switch {
+			case errors.Is(err, auth.ErrTokenExpired):
+				// clients use this to know they should refresh instead of logging in again
+				app.invalidTokenError(w, r, err, "the access token expired")
+			default:
+				app.invalidTokenError(w, r, err, "the access token is invalid")
+			}
 			return
 		}
 
-		claims, _ := jwtToken.Claims.(jwt.MapClaims)
+		claims, ok := jwtToken.Claims.(*auth.Claims)
+		if !ok {
+			app.invalidTokenError(w, r, fmt.Errorf("unexpected claims type %T", jwtToken.Claims), "the access token is invalid")
+			return
+		}
 
-		userID, err := strconv.ParseInt(fmt.Sprintf("%.f", claims["sub"]), 10, 64)
+		userID, err := claims.UserID()
 		if err != nil {
-			app.unauthorizedErrorResponse(w, r, err)
+			app.invalidTokenError(w, r, err, "the access token is invalid")
 			return
 		}
 
 		ctx := r.Context()
 
+		// logged out tokens are still valid jwts, so ask the database
+		jti := claims.Id
+		revoked, err := app.store.Tokens.IsAccessTokenRevoked(ctx, jti)
+		if err != nil {
+			app.internalServerError(w, r, err)
+			return
+		}
+		if revoked {
+			app.invalidTokenError(w, r, fmt.Errorf("token %s is revoked", jti), "the access token was revoked")
+			return
+		}
+
 		user, err := app.getUser(ctx, userID)
 		if err != nil {
 			app.unauthorizedErrorResponse(w, r, err)
 			return
 		}
 
+		// changing the password bumps the version and ends every session
+		if claims.Version != user.TokenVersion {
+			app.invalidTokenError(w, r, fmt.Errorf("token %s has version %d, the user is at %d", jti, claims.Version, user.TokenVersion), "the access token was revoked")
+			return
+		}
+
 		ctx = context.WithValue(ctx, userCtx, user)
+		ctx = context.WithValue(ctx, claimsCtx, claims)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
-func (app *application) getUser(ctx context.Context, userID int64) (*store.User, error) {
-	// if !app.config.redisCfg.enabled {
-	// 	return app.store.Users.GetByID(ctx, userID)
-	// }
+type queryTokenKey string
 
-	// user, err := app.cacheStorage.Users.Get(ctx, userID)
-	// if err != nil {
-	// 	return nil, err
-	// }
+const queryTokenCtx queryTokenKey = "QUERY_TOKEN"
 
-	user := &store.User{}
-	user = nil
+// QueryTokenMiddleware moves the access_token query param out of the url and into the
+// context, so it never ends up in the request logs. only StreamAuthMiddleware reads it.
+func (app *application) QueryTokenMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		qs := r.URL.Query()
+		token := qs.Get("access_token")
+		if token == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		qs.Del("access_token")
+
+		r = r.WithContext(context.WithValue(r.Context(), queryTokenCtx, token))
+		u := *r.URL
+		u.RawQuery = qs.Encode()
+		r.URL = &u
+		r.RequestURI = u.RequestURI()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+type claimsKey string
+
+const claimsCtx claimsKey = "CLAIMS"
+
+// getClaimsFromCtx returns the claims of the token AuthTokenMiddleware validated
+func getClaimsFromCtx(r *http.Request) *auth.Claims {
+	claims, _ := r.Context().Value(claimsCtx).(*auth.Claims)
+	return claims
+}
+
+func (app *application) getUser(ctx context.Context, userID int64) (*store.User, error) {
+	if !app.config.cache.enabled() {
+		return app.store.Users.GetById(ctx, userID)
+	}
+
+	user, err := app.cacheStorage.Users.Get(ctx, userID)
+	if err != nil {
+		// a broken cache should not lock everyone out, just go to the database
+		app.logger.Warnw("cache get failed", "user_id", userID, "error", err)
+	}
 
 	if user == nil {
-		var err error
 		user, err = app.store.Users.GetById(ctx, userID)
 		if err != nil {
 			return nil, err
 		}
 
-		// if err := app.cacheStorage.Users.Set(ctx, user); err != nil {
-		// 	return nil, err
-		// }
+		if err := app.cacheStorage.Users.Set(ctx, user); err != nil {
+			app.logger.Warnw("cache set failed", "user_id", userID, "error", err)
+		}
 	}
 
 	return user, nil
 }
+
+// invalidateUser drops the cached copy of a user, call it whenever a user changes
+func (app *application) invalidateUser(ctx context.Context, userID int64) {
+	if !app.config.cache.enabled() {
+		return
+	}
+
+	if err := app.cacheStorage.Users.Delete(ctx, userID); err != nil {
+		app.logger.Warnw("cache delete failed", "user_id", userID, "error", err)
+	}
+}
+
+// checkPostOwnership lets the owner of the post through, everyone else
+// needs a role with at least the same level as requiredRole
+func (app *application) checkPostOwnership(requiredRole string, next http.HandlerFunc) http.HandlerFunc {
+	return app.checkOwnership(requiredRole, func(r *http.Request) int64 { return getPostFromCtx(r).UserID }, next)
+}
+
+func (app *application) checkCommentOwnership(requiredRole string, next http.HandlerFunc) http.HandlerFunc {
+	return app.checkOwnership(requiredRole, func(r *http.Request) int64 { return getCommentFromCtx(r).UserID }, next)
+}
+
+// checkOwnership lets the owner through, everyone else needs at least requiredRole
+func (app *application) checkOwnership(requiredRole string, ownerID func(*http.Request) int64, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := app.getUserFromCtx(r)
+
+		// owners can always do whatever they want with their own things
+		if ownerID(r) == user.ID {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		allowed, err := app.checkRolePrecedence(r.Context(), user, requiredRole)
+		if err != nil {
+			app.internalServerError(w, r, err)
+			return
+		}
+
+		if !allowed {
+			app.forbiddenError(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
+}
+
+func (app *application) checkRolePrecedence(ctx context.Context, user *store.User, roleName string) (bool, error) {
+	role, err := app.store.Roles.GetByName(ctx, roleName)
+	if err != nil {
+		return false, err
+	}
+
+	return user.Role.Level >= role.Level, nil
+}
+
+// RealIPMiddleware replaces r.RemoteAddr with the ip of the client from X-Forwarded-For
+// or X-Real-IP. anyone can send those headers, so we only read them when the request
+// comes from one of our trusted proxies, otherwise every request could pick its own ip.
+func (app *application) RealIPMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ip := clientIP(r, app.config.trustedProxies); ip != "" {
+			r.RemoteAddr = ip
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// clientIP returns the ip of the client as told by the trusted proxies in front of us,
+// or "" when the peer is not one of them or told us nothing useful
+func clientIP(r *http.Request, trusted []*net.IPNet) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !isTrusted(net.ParseIP(host), trusted) {
+		return ""
+	}
+
+	// every proxy appends the address it got the request from, so walk from the right
+	// and stop at the first one that isn't ours. the ones further left came from the client.
+	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+		hops := strings.Split(xff, ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			ip := net.ParseIP(strings.TrimSpace(hops[i]))
+			if ip == nil {
+				return ""
+			}
+			if !isTrusted(ip, trusted) {
+				return ip.String()
+			}
+		}
+		return ""
+	}
+
+	if ip := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); ip != nil {
+		return ip.String()
+	}
+
+	return ""
+}
+
+func isTrusted(ip net.IP, trusted []*net.IPNet) bool {
+	if ip == nil {
+		return false
+	}
+	for _, n := range trusted {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseTrustedProxies reads a comma separated list of ips and cidrs like "10.0.0.0/8, 127.0.0.1"
+func parseTrustedProxies(list string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		if !strings.Contains(item, "/") {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				return nil, fmt.Errorf("trusted proxy %q is not an ip or a cidr", item)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, n, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, fmt.Errorf("trusted proxy %q is not an ip or a cidr", item)
+		}
+		nets = append(nets, n)
+	}
+
+	return nets, nil
+}
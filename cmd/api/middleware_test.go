@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	trusted, err := parseTrustedProxies("10.0.0.0/8, 192.168.1.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		peer     string
+		xff      string
+		realIP   string
+		trusted  bool // false runs the case with no trusted proxies at all
+		expected string
+	}{
+		{"untrusted peer can't pick its ip", "1.2.3.4:5000", "9.9.9.9", "8.8.8.8", true, ""},
+		{"nothing is trusted by default", "10.0.0.1:5000", "9.9.9.9", "", false, ""},
+		{"forwarded by a trusted proxy", "10.0.0.1:5000", "9.9.9.9", "", true, "9.9.9.9"},
+		{"single trusted ip", "192.168.1.1:5000", "9.9.9.9", "", true, "9.9.9.9"},
+		{"spoofed hops left of the client are ignored", "10.0.0.1:5000", "6.6.6.6, 9.9.9.9", "", true, "9.9.9.9"},
+		{"our own proxies are skipped", "10.0.0.1:5000", "9.9.9.9, 10.0.0.2", "", true, "9.9.9.9"},
+		{"only proxies in the chain", "10.0.0.1:5000", "10.0.0.3, 10.0.0.2", "", true, ""},
+		{"garbage in the chain", "10.0.0.1:5000", "9.9.9.9, nope", "", true, ""},
+		{"x-real-ip when there is no chain", "10.0.0.1:5000", "", "9.9.9.9", true, "9.9.9.9"},
+		{"bad x-real-ip", "10.0.0.1:5000", "", "nope", true, ""},
+		{"ipv6 client", "10.0.0.1:5000", "2001:db8::1", "", true, "2001:db8::1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.peer
+			if tt.xff != "" {
+				r.Header.Set("X-Forwarded-For", tt.xff)
+			}
+			if tt.realIP != "" {
+				r.Header.Set("X-Real-IP", tt.realIP)
+			}
+
+			proxies := trusted
+			if !tt.trusted {
+				proxies = nil
+			}
+
+			if got := clientIP(r, proxies); got != tt.expected {
+				t.Errorf("clientIP = %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	nets, err := parseTrustedProxies(" 10.0.0.0/8 ,, 127.0.0.1, ::1 ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nets) != 3 {
+		t.Fatalf("got %d networks, want 3", len(nets))
+	}
+
+	if nets, err := parseTrustedProxies(""); err != nil || len(nets) != 0 {
+		t.Errorf("empty list = %v, %v, want no networks", nets, err)
+	}
+
+	for _, bad := range []string{"localhost", "10.0.0.0/99", "1.2.3"} {
+		if _, err := parseTrustedProxies(bad); err == nil {
+			t.Errorf("%q should not parse", bad)
+		}
+	}
+}
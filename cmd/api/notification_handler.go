@@ -0,0 +1,139 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/sirUnchained/udemy-backend-course/internal/notifications"
+)
+
+type NotificationsResponse struct {
+	Notifications []notifications.Notification `json:"notifications"`
+	UnreadCount   int                          `json:"unread_count"`
+}
+
+type UnreadCountResponse struct {
+	UnreadCount int `json:"unread_count"`
+}
+
+// give ids to mark some notifications read, or all to mark every one of them
+type MarkNotificationsReadPayload struct {
+	IDs []int64 `json:"ids" validate:"required_without=All,max=100,dive,gte=1"`
+	All bool    `json:"all"`
+}
+
+// ListNotifications		godoc
+//
+//	@Summary		list your notifications, newest first
+//	@Tags			notifications
+//	@Produce		json
+//	@Param			unread	query		bool	false	"only unread notifications"
+//	@Param			limit	query		int		false	"page size, 1 to 50"
+//	@Param			cursor	query		string	false	"next_cursor of the previous page"
+//	@Success		200		{object}	NotificationsResponse
+//	@Failure		400		{object}	error
+//	@Failure		500		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/notifications [get]
+func (app *application) listNotificationsHandler(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+
+	limit := 20
+	if str := qs.Get("limit"); str != "" {
+		l, err := strconv.Atoi(str)
+		if err != nil || l < 1 || l > 50 {
+			app.badRequestError(w, r, errors.New("limit must be between 1 and 50"))
+			return
+		}
+		limit = l
+	}
+
+	unreadOnly := false
+	if str := qs.Get("unread"); str != "" {
+		b, err := strconv.ParseBool(str)
+		if err != nil {
+			app.badRequestError(w, r, err)
+			return
+		}
+		unreadOnly = b
+	}
+
+	user := app.getUserFromCtx(r)
+	ctx := r.Context()
+
+	page, err := app.store.Notifications.List(ctx, user.ID, limit, qs.Get("cursor"), unreadOnly)
+	if err != nil {
+		switch {
+		case errors.Is(err, notifications.ErrInvalidCursor):
+			app.badRequestError(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	unread, err := app.store.Notifications.UnreadCount(ctx, user.ID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	response := NotificationsResponse{Notifications: page.Notifications, UnreadCount: unread}
+	if err := app.paginatedJSONResponse(w, http.StatusOK, response, page.NextCursor, ""); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// MarkNotificationsRead		godoc
+//
+//	@Summary		mark notifications as read
+//	@Tags			notifications
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		MarkNotificationsReadPayload	true	"ids to mark, or all"
+//	@Success		200		{object}	UnreadCountResponse				"what is left unread"
+//	@Failure		400		{object}	error
+//	@Failure		500		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/notifications/read [post]
+func (app *application) markNotificationsReadHandler(w http.ResponseWriter, r *http.Request) {
+	var payload MarkNotificationsReadPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	// an empty ids list would mean "all" to the store, make people say it
+	ids := payload.IDs
+	if payload.All {
+		ids = nil
+	} else if len(ids) == 0 {
+		app.badRequestError(w, r, errors.New("give the ids to mark read or set all"))
+		return
+	}
+
+	user := app.getUserFromCtx(r)
+	ctx := r.Context()
+
+	if _, err := app.store.Notifications.MarkRead(ctx, user.ID, ids); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	unread, err := app.store.Notifications.UnreadCount(ctx, user.ID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	response := UnreadCountResponse{UnreadCount: unread}
+	if err := app.jsonResponse(w, http.StatusOK, response); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"strconv"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/sirUnchained/udemy-backend-course/internal/extract"
 	"github.com/sirUnchained/udemy-backend-course/internal/store"
 )
 
@@ -42,10 +43,11 @@ func (app *application) createPostHandler(w http.ResponseWriter, r *http.Request
 
 	user := app.getUserFromCtx(r)
 	post := &store.Post{
-		Title:   payload.Title,
-		Content: payload.Content,
-		UserID:  user.ID,
-		Tags:    payload.Tags,
+		Title:    payload.Title,
+		Content:  payload.Content,
+		UserID:   user.ID,
+		Tags:     extract.MergeTags(payload.Tags, extract.Hashtags(payload.Content)),
+		Mentions: extract.Mentions(payload.Content),
 	}
 
 	ctx := r.Context()
@@ -62,8 +64,10 @@ func (app *application) createPostHandler(w http.ResponseWriter, r *http.Request
 
 func (app *application) getPostByIdHandler(w http.ResponseWriter, r *http.Request) {
 	post := getPostFromCtx(r)
+	user := app.getUserFromCtx(r)
+	ctx := r.Context()
 
-	comments, err := app.store.Comments.GetCommentsByPostId(r.Context(), int64(post.ID))
+	comments, err := app.store.Comments.GetCommentsByPostId(ctx, int64(post.ID), user.ID)
 	if err != nil {
 		app.internalServerError(w, r, err)
 		return
@@ -71,7 +75,24 @@ func (app *application) getPostByIdHandler(w http.ResponseWriter, r *http.Reques
 
 	post.Comments = comments
 
-	if err := app.jsonResponse(w, http.StatusOK, post); err != nil {
+	reactions, err := app.store.Reactions.GetSummary(ctx, post.ID, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.notFoundError(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	response := store.PostWithMetadata{
+		Post:            *post,
+		CommentsCount:   len(comments),
+		ReactionSummary: *reactions,
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, response); err != nil {
 		app.internalServerError(w, r, err)
 		return
 	}
@@ -81,6 +102,14 @@ func (app *application) deletePostByIdHandler(w http.ResponseWriter, r *http.Req
 	post := getPostFromCtx(r)
 
 	ctx := r.Context()
+
+	// the rows go with the post, the blobs we delete ourselves
+	attachments, err := app.store.Attachments.ListByPost(ctx, post.ID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
 	if err := app.store.Posts.DeleteById(ctx, int64(post.ID)); err != nil {
 		switch {
 		case errors.Is(err, store.ErrNotFound):
@@ -91,6 +120,10 @@ func (app *application) deletePostByIdHandler(w http.ResponseWriter, r *http.Req
 		return
 	}
 
+	for _, a := range attachments {
+		app.deleteBlobs(a.BlobKey, a.ThumbnailKey)
+	}
+
 	if err := app.jsonResponse(w, http.StatusOK, "post is now deleted"); err != nil {
 		app.internalServerError(w, r, err)
 		return
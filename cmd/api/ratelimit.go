@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/sirUnchained/udemy-backend-course/internal/ratelimiter"
+)
+
+// route groups with their own limits
+const (
+	rateLimitAuth   = "auth"   // login and register, keyed by ip
+	rateLimitPublic = "public" // every other anonymous route, keyed by ip
+	rateLimitUser   = "user"   // routes behind AuthTokenMiddleware, keyed by user id
+)
+
+// newRateLimiters builds one limiter per route group, rdb is only needed for the redis backend
+func newRateLimiters(cfg rateLimiterConfig, rdb *redis.Client) map[string]ratelimiter.Limiter {
+	groups := map[string]ratelimiter.Config{
+		rateLimitAuth:   cfg.auth,
+		rateLimitPublic: cfg.public,
+		rateLimitUser:   cfg.user,
+	}
+
+	limiters := make(map[string]ratelimiter.Limiter, len(groups))
+	for group, groupCfg := range groups {
+		switch {
+		case cfg.backend == "redis":
+			limiters[group] = ratelimiter.NewRedisFixedWindowLimiter(rdb, groupCfg, "ratelimit:"+group)
+		case cfg.strategy == "fixed":
+			limiters[group] = ratelimiter.NewFixedWindowLimiter(groupCfg)
+		default:
+			limiters[group] = ratelimiter.NewTokenBucketLimiter(groupCfg)
+		}
+	}
+
+	return limiters
+}
+
+// keyFunc tells the rate limiter who is making the request
+type keyFunc func(r *http.Request) string
+
+// keyByIP uses the address set by RealIPMiddleware, the peer itself unless a trusted proxy forwarded the request
+func keyByIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		// RealIPMiddleware already removed the port
+		return "ip:" + r.RemoteAddr
+	}
+	return "ip:" + ip
+}
+
+// keyByUser must run after AuthTokenMiddleware
+func (app *application) keyByUser(r *http.Request) string {
+	user := app.getUserFromCtx(r)
+	if user == nil {
+		return keyByIP(r)
+	}
+	return fmt.Sprintf("user:%d", user.ID)
+}
+
+func (app *application) RateLimiterMiddleware(group string, key keyFunc) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			limiter, ok := app.rateLimiters[group]
+			if !app.config.rateLimiter.enabled || !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			result, err := limiter.Allow(r.Context(), key(r))
+			if err != nil {
+				// don't take the whole api down because redis is gone
+				app.logger.Warnw("rate limiter failed", "group", group, "error", err)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Set("X-RateLimit-Limit", strconv.Itoa(result.Limit))
+			w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+			w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(result.Reset.Unix(), 10))
+
+			if !result.Allowed {
+				retryAfter := int(math.Ceil(result.RetryAfter.Seconds()))
+				app.rateLimitExceededError(w, r, strconv.Itoa(retryAfter))
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
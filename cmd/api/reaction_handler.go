@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/sirUnchained/udemy-backend-course/internal/store"
+)
+
+// ReactToPost		godoc
+//
+//	@Summary		react to a post
+//	@Description	kind is one of like, love, laugh, wow, sad or angry. reacting twice with the same kind does nothing
+//	@Tags			reactions
+//	@Produce		json
+//	@Param			postid	path		int		true	"Post ID"
+//	@Param			kind	path		string	true	"reaction kind"
+//	@Success		200		{object}	store.ReactionSummary
+//	@Failure		400		{object}	error
+//	@Failure		404		{object}	error
+//	@Failure		500		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/posts/{postid}/reactions/{kind} [put]
+func (app *application) addReactionHandler(w http.ResponseWriter, r *http.Request) {
+	app.changeReaction(w, r, app.store.Reactions.Add)
+}
+
+// RemoveReaction		godoc
+//
+//	@Summary		take back a reaction to a post
+//	@Tags			reactions
+//	@Produce		json
+//	@Param			postid	path		int		true	"Post ID"
+//	@Param			kind	path		string	true	"reaction kind"
+//	@Success		200		{object}	store.ReactionSummary
+//	@Failure		400		{object}	error
+//	@Failure		404		{object}	error
+//	@Failure		500		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/posts/{postid}/reactions/{kind} [delete]
+func (app *application) removeReactionHandler(w http.ResponseWriter, r *http.Request) {
+	app.changeReaction(w, r, app.store.Reactions.Remove)
+}
+
+// changeReaction runs change for the current user and responds with the new summary
+func (app *application) changeReaction(w http.ResponseWriter, r *http.Request, change func(ctx context.Context, postID, userID int64, kind string) error) {
+	post := getPostFromCtx(r)
+	user := app.getUserFromCtx(r)
+	kind := chi.URLParam(r, "kind")
+
+	ctx := r.Context()
+	if err := change(ctx, post.ID, user.ID, kind); err != nil {
+		switch {
+		case errors.Is(err, store.ErrInvalidReaction):
+			app.badRequestError(w, r, err)
+		case errors.Is(err, store.ErrNotFound):
+			app.notFoundError(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	summary, err := app.store.Reactions.GetSummary(ctx, post.ID, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.notFoundError(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, summary); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/sirUnchained/udemy-backend-course/internal/store"
+)
+
+// SearchPosts		godoc
+//
+//	@Summary		full text search over posts
+//	@Description	q uses web search syntax: "quoted phrase", -excluded, or. title matches rank above tag matches and tag matches above content matches
+//	@Tags			search
+//	@Produce		json
+//	@Param			q			query		string	true	"search query"
+//	@Param			author_id	query		int		false	"only posts of this user"
+//	@Param			tag			query		string	false	"only posts with this tag"
+//	@Param			order		query		string	false	"relevance (default) or date"
+//	@Param			limit		query		int		false	"page size, 1 to 50"
+//	@Param			cursor		query		string	false	"next_cursor of the previous page"
+//	@Success		200			{object}	[]store.PostSearchResult
+//	@Failure		400			{object}	error
+//	@Failure		500			{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/search/posts [get]
+func (app *application) searchPostsHandler(w http.ResponseWriter, r *http.Request) {
+	q, err := store.PostSearchQuery{Order: store.SearchByRelevance, Limit: 20}.Parse(r)
+	if err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(q); err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	user := app.getUserFromCtx(r)
+
+	page, err := app.store.Search.SearchPosts(r.Context(), user.ID, q)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.paginatedJSONResponse(w, http.StatusOK, page.Posts, page.NextCursor, ""); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// SearchUsers		godoc
+//
+//	@Summary		search active users by username
+//	@Description	fuzzy mode tolerates typos and ranks by similarity, prefix mode is for @mention autocomplete
+//	@Tags			search
+//	@Produce		json
+//	@Param			q		query		string	true	"username or part of it, a leading @ is ignored"
+//	@Param			mode	query		string	false	"fuzzy (default) or prefix"
+//	@Param			limit	query		int		false	"how many, 1 to 50"
+//	@Success		200		{object}	[]store.UserSearchResult
+//	@Failure		400		{object}	error
+//	@Failure		500		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/search/users [get]
+func (app *application) searchUsersHandler(w http.ResponseWriter, r *http.Request) {
+	q, err := store.UserSearchQuery{Mode: store.UserSearchFuzzy, Limit: 10}.Parse(r)
+	if err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(q); err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	user := app.getUserFromCtx(r)
+
+	users, err := app.store.Search.SearchUsers(r.Context(), user.ID, q)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, users); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
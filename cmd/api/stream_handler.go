@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/sirUnchained/udemy-backend-course/internal/stream"
+)
+
+const (
+	// a comment line every now and then keeps proxies from closing idle streams
+	streamHeartbeat = time.Second * 15
+	// every write gets its own deadline, the server WriteTimeout is far too short for a stream
+	streamWriteTimeout = time.Second * 10
+	// how long browsers wait before they reconnect
+	streamRetry = time.Second * 3
+
+	appEventsChannel = "app_events"
+)
+
+// what the database triggers send on the app_events channel
+type appEvent struct {
+	Type   string `json:"type"`
+	ID     int64  `json:"id"`
+	UserID int64  `json:"user_id"`
+}
+
+// the "post" event, just enough for the client to show "new posts" and load them
+type StreamPost struct {
+	ID        int64     `json:"id"`
+	UserID    int64     `json:"user_id"`
+	Username  string    `json:"username"`
+	Title     string    `json:"title"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Stream		godoc
+//
+//	@Summary		server-sent events with your new notifications and new posts of people you follow
+//	@Description	events are "notification" and "post", reconnect with Last-Event-ID to get the ones you missed
+//	@Tags			stream
+//	@Produce		text/event-stream
+//	@Param			Last-Event-ID	header		string	false	"id of the last event you received"
+//	@Param			access_token	query		string	false	"access token, EventSource can't set the Authorization header"
+//	@Success		200				{string}	string	"event stream"
+//	@Failure		400				{object}	error
+//	@Failure		500				{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/stream [get]
+func (app *application) streamHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.getUserFromCtx(r)
+
+	// browsers send the header when they reconnect, the query param is for everyone else
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("last_event_id")
+	}
+
+	var lastID uint64
+	if lastEventID != "" {
+		id, err := strconv.ParseUint(lastEventID, 10, 64)
+		if err != nil {
+			app.badRequestError(w, r, err)
+			return
+		}
+		lastID = id
+	}
+
+	rc := http.NewResponseController(w)
+	write := func(format string, args ...any) error {
+		if err := rc.SetWriteDeadline(time.Now().Add(streamWriteTimeout)); err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, format, args...); err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+
+	sub, missed := app.hub.Subscribe(user.ID, lastID)
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	// nginx buffers responses by default, that would hold events back
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	// tell the client how long to wait before reconnecting, and flush the headers
+	if err := write("retry: %d\n\n", streamRetry.Milliseconds()); err != nil {
+		return
+	}
+
+	for _, event := range missed {
+		if err := writeEvent(write, event); err != nil {
+			return
+		}
+	}
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-sub.Events:
+			if !ok {
+				// dropped by the hub (too slow, or we are shutting down), the client reconnects
+				return
+			}
+			if err := writeEvent(write, event); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if err := write(": ping\n\n"); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func writeEvent(write func(string, ...any) error, event stream.Event) error {
+	return write("id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, event.Data)
+}
+
+// eventsListener listens for the app_events of the database and publishes them
+// on the hub. it runs in the background and returns when ctx is done.
+func (app *application) eventsListener(ctx context.Context) {
+	listener := pq.NewListener(app.config.db.addr, time.Second*10, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			app.logger.Errorw("app events listener", "event", ev, "error", err)
+		}
+	})
+	defer listener.Close()
+
+	if err := listener.Listen(appEventsChannel); err != nil {
+		app.logger.Errorw("error listening for app events", "error", err)
+		return
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case n := <-listener.Notify:
+			// nil after a reconnect, anything sent in between is lost
+			if n == nil {
+				continue
+			}
+
+			var event appEvent
+			if err := json.Unmarshal([]byte(n.Extra), &event); err != nil {
+				app.logger.Errorw("bad app event", "payload", n.Extra, "error", err)
+				continue
+			}
+
+			if err := app.publishAppEvent(ctx, event); err != nil {
+				app.logger.Errorw("error publishing app event", "type", event.Type, "id", event.ID, "error", err)
+			}
+		case <-time.After(time.Minute):
+			// nothing for a while, make sure the connection is still alive
+			go listener.Ping()
+		}
+	}
+}
+
+func (app *application) publishAppEvent(ctx context.Context, event appEvent) error {
+	switch event.Type {
+	case "notification":
+		n, err := app.store.Notifications.Get(ctx, event.ID)
+		if err != nil {
+			return err
+		}
+		return app.hub.Publish(n.UserID, "notification", n)
+
+	case "post":
+		post, err := app.store.Posts.GetById(ctx, event.ID)
+		if err != nil {
+			return err
+		}
+
+		author, err := app.getUser(ctx, post.UserID)
+		if err != nil {
+			return err
+		}
+
+		followers, err := app.store.Followers.FanoutIDs(ctx, post.UserID)
+		if err != nil {
+			return err
+		}
+
+		data := StreamPost{
+			ID:        post.ID,
+			UserID:    post.UserID,
+			Username:  author.UserName,
+			Title:     post.Title,
+			CreatedAt: post.CreatedAt,
+		}
+		for _, id := range followers {
+			if err := app.hub.Publish(id, "post", data); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/sirUnchained/udemy-backend-course/internal/extract"
+	"github.com/sirUnchained/udemy-backend-course/internal/store"
+)
+
+// trending tags look back at most a week, anything longer is not "trending"
+const maxTrendingWindow = time.Hour * 24 * 7
+
+// GetTagPosts		godoc
+//
+//	@Summary		list the posts with a tag, newest first
+//	@Tags			tags
+//	@Produce		json
+//	@Param			tag		path		string	true	"tag, with or without the #"
+//	@Param			limit	query		int		false	"page size, 1 to 50"
+//	@Param			cursor	query		string	false	"next_cursor of the previous page"
+//	@Success		200		{object}	[]store.PostWithMetadata
+//	@Failure		400		{object}	error
+//	@Failure		500		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/tags/{tag}/posts [get]
+func (app *application) getTagPostsHandler(w http.ResponseWriter, r *http.Request) {
+	tag := extract.NormalizeTag(chi.URLParam(r, "tag"))
+	if tag == "" {
+		app.badRequestError(w, r, errors.New("tag is empty"))
+		return
+	}
+
+	q, err := store.PaginatedQuery{Limit: 20}.Parse(r)
+	if err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(q); err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	user := app.getUserFromCtx(r)
+
+	page, err := app.store.Tags.GetPosts(r.Context(), user.ID, tag, q)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.paginatedJSONResponse(w, http.StatusOK, page.Posts, page.NextCursor, ""); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// TrendingTags		godoc
+//
+//	@Summary		tags used by the most authors lately
+//	@Tags			tags
+//	@Produce		json
+//	@Param			window	query		string	false	"how far back to look, like 24h (default) or 90m, at most 168h"
+//	@Param			limit	query		int		false	"how many, 1 to 50"
+//	@Success		200		{object}	[]store.TrendingTag
+//	@Failure		400		{object}	error
+//	@Failure		500		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/tags/trending [get]
+func (app *application) trendingTagsHandler(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+
+	window := time.Hour * 24
+	if str := qs.Get("window"); str != "" {
+		d, err := time.ParseDuration(str)
+		if err != nil || d <= 0 || d > maxTrendingWindow {
+			app.badRequestError(w, r, errors.New("window must be a duration between 0 and 168h"))
+			return
+		}
+		window = d
+	}
+
+	limit := 10
+	if str := qs.Get("limit"); str != "" {
+		l, err := strconv.Atoi(str)
+		if err != nil || l < 1 || l > 50 {
+			app.badRequestError(w, r, errors.New("limit must be between 1 and 50"))
+			return
+		}
+		limit = l
+	}
+
+	tags, err := app.store.Tags.Trending(r.Context(), window, limit)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, tags); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
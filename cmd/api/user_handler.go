@@ -5,6 +5,7 @@ import (
 	"errors"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/sirUnchained/udemy-backend-course/internal/store"
@@ -12,12 +13,42 @@ import (
 
 type userKey string
 
-const userCtx userKey = "USER"
+// userCtx is the authenticated user, targetUserCtx is the user in the url
+const (
+	userCtx       userKey = "USER"
+	targetUserCtx userKey = "TARGET_USER"
+)
 
 type FollowUser struct {
 	UserID int64 `json:"user_id"`
 }
 
+// UserProfile is the public view of a user plus their follow counts, Relationship is left out on your own profile
+type UserProfile struct {
+	*store.PublicUser
+	FollowersCount int                 `json:"followers_count"`
+	FollowingCount int                 `json:"following_count"`
+	Relationship   *store.Relationship `json:"relationship,omitempty"`
+}
+
+// MyProfile is what you see of yourself, with the private fields like the email
+type MyProfile struct {
+	*store.PrivateUser
+	FollowersCount int `json:"followers_count"`
+	FollowingCount int `json:"following_count"`
+}
+
+// only the fields that are sent change, send "" to clear one.
+// keep the limits in sync with the columns in the users table.
+type UpdateProfilePayload struct {
+	UserName    *string `json:"username" validate:"omitempty,min=3,max=100,username"`
+	DisplayName *string `json:"display_name" validate:"omitempty,max=64"`
+	Bio         *string `json:"bio" validate:"omitempty,max=300"`
+	AvatarURL   *string `json:"avatar_url" validate:"omitempty,max=2048,http_url|len=0"`
+	Website     *string `json:"website" validate:"omitempty,max=2048,http_url|len=0"`
+	Location    *string `json:"location" validate:"omitempty,max=64"`
+}
+
 // GetUser		 godoc
 //
 //	@Summary		fetch a user by id
@@ -26,20 +57,241 @@ type FollowUser struct {
 //	@Accept			json
 //	@Produce		json
 //	@Param			userid	path		int	true	"User ID"
-//	@Success		200		{object}	store.User
+//	@Success		200		{object}	UserProfile
 //	@Failure		400		{object}	error
 //	@Failure		404		{object}	error
 //	@Failure		500		{object}	error
 //	@Security		ApiKeyAuth
-//	@Router			/users/{userid}/follow 	[PUT]
+//	@Router			/users/{userid} 	[get]
 func (app *application) getUserHandler(w http.ResponseWriter, r *http.Request) {
+	viewer := app.getUserFromCtx(r)
+	user := getTargetUserFromCtx(r)
+	ctx := r.Context()
+
+	profile := UserProfile{PublicUser: user.Public()}
+
+	var err error
+	profile.FollowersCount, profile.FollowingCount, err = app.store.Followers.Counts(ctx, user.ID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if viewer.ID != user.ID {
+		profile.Relationship, err = app.store.Followers.Relationship(ctx, viewer.ID, user.ID)
+		if err != nil {
+			app.internalServerError(w, r, err)
+			return
+		}
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, profile); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+}
+
+// GetMyProfile		godoc
+//
+//	@Summary		fetch your own profile, with your email
+//	@Tags			user
+//	@Produce		json
+//	@Success		200	{object}	MyProfile
+//	@Failure		500	{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/users/me [get]
+func (app *application) getMyProfileHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.getUserFromCtx(r)
+
+	profile := MyProfile{PrivateUser: user.Private()}
+
+	var err error
+	profile.FollowersCount, profile.FollowingCount, err = app.store.Followers.Counts(r.Context(), user.ID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, profile); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// UpdateMyProfile		godoc
+//
+//	@Summary		change your username or profile
+//	@Description	only the fields you send change, send "" to clear one
+//	@Tags			user
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		UpdateProfilePayload	true	"fields to change"
+//	@Success		200		{object}	store.PrivateUser
+//	@Failure		400		{object}	error
+//	@Failure		409		{object}	error	"the username is taken"
+//	@Failure		500		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/users/me [patch]
+func (app *application) updateMyProfileHandler(w http.ResponseWriter, r *http.Request) {
+	var payload UpdateProfilePayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	// leading and trailing spaces never mean anything here
+	for _, field := range []*string{payload.UserName, payload.DisplayName, payload.Bio, payload.AvatarURL, payload.Website, payload.Location} {
+		if field != nil {
+			*field = strings.TrimSpace(*field)
+		}
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	// work on a copy, the user in the context may be shared with the cache
+	user := *app.getUserFromCtx(r)
+	if payload.UserName != nil {
+		if *payload.UserName == "" {
+			app.badRequestError(w, r, errors.New("username can't be empty"))
+			return
+		}
+		user.UserName = *payload.UserName
+	}
+	if payload.DisplayName != nil {
+		user.DisplayName = *payload.DisplayName
+	}
+	if payload.Bio != nil {
+		user.Bio = *payload.Bio
+	}
+	if payload.AvatarURL != nil {
+		user.AvatarURL = *payload.AvatarURL
+	}
+	if payload.Website != nil {
+		user.Website = *payload.Website
+	}
+	if payload.Location != nil {
+		user.Location = *payload.Location
+	}
+
+	ctx := r.Context()
+	if err := app.store.Users.UpdateProfile(ctx, &user); err != nil {
+		switch {
+		case errors.Is(err, store.ErrDuplicatedUsername):
+			app.conflictRequestError(w, r, err)
+		case errors.Is(err, store.ErrNotFound):
+			app.notFoundError(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	app.invalidateUser(ctx, user.ID)
+
+	if err := app.jsonResponse(w, http.StatusOK, user.Private()); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// ListFollowers		godoc
+//
+//	@Summary		list the users following a user
+//	@Tags			user
+//	@Produce		json
+//	@Param			userid	path		int		true	"User ID"
+//	@Param			limit	query		int		false	"page size, 1 to 50"
+//	@Param			cursor	query		string	false	"next_cursor of the previous page"
+//	@Success		200		{object}	[]store.Connection
+//	@Failure		400		{object}	error
+//	@Failure		404		{object}	error
+//	@Failure		500		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/users/{userid}/followers [get]
+func (app *application) listFollowersHandler(w http.ResponseWriter, r *http.Request) {
+	app.listConnections(w, r, app.store.Followers.ListFollowers)
+}
+
+// ListFollowing		godoc
+//
+//	@Summary		list the users a user follows
+//	@Tags			user
+//	@Produce		json
+//	@Param			userid	path		int		true	"User ID"
+//	@Param			limit	query		int		false	"page size, 1 to 50"
+//	@Param			cursor	query		string	false	"next_cursor of the previous page"
+//	@Success		200		{object}	[]store.Connection
+//	@Failure		400		{object}	error
+//	@Failure		404		{object}	error
+//	@Failure		500		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/users/{userid}/following [get]
+func (app *application) listFollowingHandler(w http.ResponseWriter, r *http.Request) {
+	app.listConnections(w, r, app.store.Followers.ListFollowing)
+}
+
+func (app *application) listConnections(w http.ResponseWriter, r *http.Request, list func(context.Context, int64, int64, store.PaginatedQuery) (*store.ConnectionPage, error)) {
+	viewer := app.getUserFromCtx(r)
+	user := getTargetUserFromCtx(r)
+
+	q, err := store.PaginatedQuery{Limit: 20}.Parse(r)
+	if err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(q); err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	page, err := list(r.Context(), viewer.ID, user.ID, q)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.paginatedJSONResponse(w, http.StatusOK, page.Users, page.NextCursor, ""); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// SuggestedUsers		godoc
+//
+//	@Summary		users followed by the people you follow
+//	@Tags			user
+//	@Produce		json
+//	@Param			limit	query		int	false	"how many, 1 to 50"
+//	@Success		200		{object}	[]store.SuggestedUser
+//	@Failure		400		{object}	error
+//	@Failure		500		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/users/suggestions [get]
+func (app *application) suggestedUsersHandler(w http.ResponseWriter, r *http.Request) {
 	user := app.getUserFromCtx(r)
 
-	if err := app.jsonResponse(w, http.StatusOK, user); err != nil {
+	q, err := store.PaginatedQuery{Limit: 10}.Parse(r)
+	if err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(q); err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	users, err := app.store.Followers.Suggestions(r.Context(), user.ID, q.Limit)
+	if err != nil {
 		app.internalServerError(w, r, err)
 		return
 	}
 
+	if err := app.jsonResponse(w, http.StatusOK, users); err != nil {
+		app.internalServerError(w, r, err)
+	}
 }
 
 // FollowUser		godoc
@@ -77,6 +329,8 @@ func (app *application) followUserHandler(w http.ResponseWriter, r *http.Request
 		switch {
 		case errors.Is(err, store.Errconflict):
 			app.badRequestError(w, r, err)
+		case errors.Is(err, store.ErrBlocked):
+			app.forbiddenError(w, r)
 		default:
 			app.internalServerError(w, r, err)
 		}
@@ -137,6 +391,7 @@ func (app *application) unfollowUserHandler(w http.ResponseWriter, r *http.Reque
 	}
 }
 
+// userContextMiddleware loads the user in the url, it doesn't replace the authenticated user
 func (app *application) userContextMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		id_str := chi.URLParam(r, "userid")
@@ -148,7 +403,7 @@ func (app *application) userContextMiddleware(next http.Handler) http.Handler {
 		}
 
 		ctx := r.Context()
-		user, err := app.store.Users.GetById(ctx, id)
+		user, err := app.getUser(ctx, id)
 		if err != nil {
 			switch {
 			case errors.Is(err, store.ErrNotFound):
@@ -160,11 +415,16 @@ func (app *application) userContextMiddleware(next http.Handler) http.Handler {
 			}
 		}
 
-		ctx = context.WithValue(ctx, userCtx, user)
+		ctx = context.WithValue(ctx, targetUserCtx, user)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
+func getTargetUserFromCtx(r *http.Request) *store.User {
+	user, _ := r.Context().Value(targetUserCtx).(*store.User)
+	return user
+}
+
 func (app *application) getUserFromCtx(r *http.Request) *store.User {
 	user, _ := r.Context().Value(userCtx).(*store.User)
 	return user
@@ -0,0 +1,23 @@
+DROP INDEX IF EXISTS idx_comments_post_id;
+
+DROP INDEX IF EXISTS idx_posts_user_id;
+
+DROP INDEX IF EXISTS idx_users_username;
+
+DROP INDEX IF EXISTS idx_posts_tags;
+
+DROP INDEX IF EXISTS idx_posts_title;
+
+DROP INDEX IF EXISTS idx_comments_content;
+
+DROP TABLE IF EXISTS user_invitations;
+
+DROP TABLE IF EXISTS followers;
+
+DROP TABLE IF EXISTS comments;
+
+DROP TABLE IF EXISTS posts;
+
+DROP TABLE IF EXISTS users;
+
+DROP TABLE IF EXISTS roles;
@@ -1,4 +1,16 @@
-CREATE DATABASE social;
+CREATE TABLE IF NOT EXISTS roles (
+    id bigserial PRIMARY KEY,
+    name VARCHAR(255) UNIQUE NOT NULL,
+    level INT NOT NULL DEFAULT 0, -- bigger level wins, admin > moderator > user
+    description TEXT NOT NULL DEFAULT ''
+);
+
+INSERT INTO roles (name, level, description)
+VALUES
+    ('user', 1, 'a user can create posts and comments'),
+    ('moderator', 2, 'a moderator can delete other users posts and comments'),
+    ('admin', 3, 'an admin can do everything')
+ON CONFLICT (name) DO NOTHING;
 
 CREATE TABLE IF NOT EXISTS users (
     id bigserial PRIMARY KEY,
@@ -6,6 +18,7 @@ CREATE TABLE IF NOT EXISTS users (
     username VARCHAR(255) UNIQUE NOT NULL,
     password bytea NOT NULL, -- password is hashed so we use bytea
     is_active BOOLEAN DEFAULT FALSE,
+    role_id bigint NOT NULL DEFAULT 1 REFERENCES roles (id),
     created_at TIMESTAMP(0)
     WITH
         TIME ZONE NOT NULL DEFAULT NOW()
@@ -51,7 +64,7 @@ CREATE TABLE IF NOT EXISTS user_invitations (
         TIME ZONE NOT NULL
 );
 
-CREATE Extention IF NOT EXISTS pg_trgm;
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
 
 CREATE INDEX IF NOT EXISTS idx_comments_content ON comments USING gin (content gin_trgm_ops);
 
@@ -63,4 +76,4 @@ CREATE INDEX IF NOT EXISTS idx_users_username ON users (username);
 
 CREATE INDEX IF NOT EXISTS idx_posts_user_id ON posts (user_id);
 
-CREATE INDEX IF NOT EXISTS idx_comments_post_id ON comments (post_id);
\ No newline at end of file
+CREATE INDEX IF NOT EXISTS idx_comments_post_id ON comments (post_id);
@@ -0,0 +1,4 @@
+-- the feed pages on (created_at, id) and looks people up by follower_id
+CREATE INDEX IF NOT EXISTS idx_posts_user_id_created_at_id ON posts (user_id, created_at, id);
+
+CREATE INDEX IF NOT EXISTS idx_followers_follower_id ON followers (follower_id);
@@ -0,0 +1,9 @@
+DROP INDEX IF EXISTS idx_comments_parent_id;
+
+DROP INDEX IF EXISTS idx_comments_post_id_created_at_id;
+
+ALTER TABLE comments
+    DROP COLUMN IF EXISTS deleted_at,
+    DROP COLUMN IF EXISTS updated_at,
+    DROP COLUMN IF EXISTS depth,
+    DROP COLUMN IF EXISTS parent_id;
@@ -0,0 +1,11 @@
+-- replies point at their parent, depth is 0 for top level comments.
+-- deleted comments keep their row (deleted_at is set) so replies still have a parent
+ALTER TABLE comments
+    ADD COLUMN IF NOT EXISTS parent_id bigint REFERENCES comments (id) ON DELETE CASCADE,
+    ADD COLUMN IF NOT EXISTS depth INT NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS updated_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP(0) WITH TIME ZONE;
+
+CREATE INDEX IF NOT EXISTS idx_comments_post_id_created_at_id ON comments (post_id, created_at, id);
+
+CREATE INDEX IF NOT EXISTS idx_comments_parent_id ON comments (parent_id);
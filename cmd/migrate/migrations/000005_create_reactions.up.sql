@@ -0,0 +1,19 @@
+-- a user can leave each kind of reaction once per post
+CREATE TABLE IF NOT EXISTS reactions (
+    post_id bigint NOT NULL REFERENCES posts (id) ON DELETE CASCADE,
+    user_id bigint NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    kind VARCHAR(16) NOT NULL CHECK (kind IN ('like', 'love', 'laugh', 'wow', 'sad', 'angry')),
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (post_id, user_id, kind)
+);
+
+CREATE INDEX IF NOT EXISTS idx_reactions_user_id ON reactions (user_id);
+
+-- counting reactions on every read gets slow on popular posts,
+-- so this table is kept in sync in the same transaction as reactions
+CREATE TABLE IF NOT EXISTS post_reaction_counts (
+    post_id bigint NOT NULL REFERENCES posts (id) ON DELETE CASCADE,
+    kind VARCHAR(16) NOT NULL,
+    count bigint NOT NULL DEFAULT 0 CHECK (count >= 0),
+    PRIMARY KEY (post_id, kind)
+);
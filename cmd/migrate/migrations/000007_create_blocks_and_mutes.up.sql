@@ -0,0 +1,19 @@
+-- a block works both ways: neither user sees or follows the other.
+-- a mute only hides the muted user from the muter.
+CREATE TABLE IF NOT EXISTS blocks (
+    blocker_id bigint NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    blocked_id bigint NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (blocker_id, blocked_id),
+    CHECK (blocker_id <> blocked_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_blocks_blocked_id ON blocks (blocked_id);
+
+CREATE TABLE IF NOT EXISTS mutes (
+    muter_id bigint NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    muted_id bigint NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (muter_id, muted_id),
+    CHECK (muter_id <> muted_id)
+);
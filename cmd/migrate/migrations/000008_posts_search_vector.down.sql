@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_posts_search_vector;
+
+ALTER TABLE posts DROP COLUMN IF EXISTS search_vector;
+
+DROP FUNCTION IF EXISTS immutable_array_to_string(text[], text);
@@ -0,0 +1,14 @@
+-- array_to_string is only STABLE and generated columns want IMMUTABLE functions,
+-- it is immutable for text arrays so we wrap it
+CREATE OR REPLACE FUNCTION immutable_array_to_string(text[], text)
+RETURNS text LANGUAGE sql IMMUTABLE PARALLEL SAFE AS
+$$ SELECT array_to_string($1, $2) $$;
+
+-- title weighs more than tags and tags weigh more than content
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS search_vector tsvector GENERATED ALWAYS AS (
+    setweight(to_tsvector('english', coalesce(title, '')), 'A') ||
+    setweight(to_tsvector('english', immutable_array_to_string(tags, ' ')), 'B') ||
+    setweight(to_tsvector('english', coalesce(content, '')), 'C')
+) STORED;
+
+CREATE INDEX IF NOT EXISTS idx_posts_search_vector ON posts USING gin (search_vector);
@@ -0,0 +1,4 @@
+-- fuzzy search uses the trigram index, autocomplete the prefix one
+CREATE INDEX IF NOT EXISTS idx_users_username_trgm ON users USING gin (username gin_trgm_ops);
+
+CREATE INDEX IF NOT EXISTS idx_users_username_prefix ON users (lower(username) text_pattern_ops);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_posts_created_at;
+
+DROP TABLE IF EXISTS mentions;
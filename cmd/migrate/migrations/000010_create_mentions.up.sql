@@ -0,0 +1,16 @@
+-- a mention is in a post, or in a comment of that post when comment_id is set
+CREATE TABLE IF NOT EXISTS mentions (
+    id bigserial PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES users (id) ON DELETE CASCADE, -- who was mentioned
+    author_id bigint NOT NULL REFERENCES users (id) ON DELETE CASCADE, -- who mentioned them
+    post_id bigint NOT NULL REFERENCES posts (id) ON DELETE CASCADE,
+    comment_id bigint REFERENCES comments (id) ON DELETE CASCADE,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_mentions_post ON mentions (user_id, post_id) WHERE comment_id IS NULL;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_mentions_comment ON mentions (user_id, comment_id) WHERE comment_id IS NOT NULL;
+
+-- trending tags only look at recent posts
+CREATE INDEX IF NOT EXISTS idx_posts_created_at ON posts (created_at);
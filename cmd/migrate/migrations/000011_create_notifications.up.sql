@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS notifications (
+    id bigserial PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES users (id) ON DELETE CASCADE, -- who gets it
+    actor_id bigint NOT NULL REFERENCES users (id) ON DELETE CASCADE, -- who caused it
+    kind VARCHAR(32) NOT NULL,
+    post_id bigint REFERENCES posts (id) ON DELETE CASCADE,
+    comment_id bigint REFERENCES comments (id) ON DELETE CASCADE,
+    read_at TIMESTAMP(0) WITH TIME ZONE,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_notifications_user_id_id ON notifications (user_id, id);
+
+CREATE INDEX IF NOT EXISTS idx_notifications_unread ON notifications (user_id) WHERE read_at IS NULL;
@@ -0,0 +1,5 @@
+DROP TRIGGER IF EXISTS posts_app_event ON posts;
+
+DROP TRIGGER IF EXISTS notifications_app_event ON notifications;
+
+DROP FUNCTION IF EXISTS notify_app_event();
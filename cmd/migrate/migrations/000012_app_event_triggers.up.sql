@@ -0,0 +1,22 @@
+-- new notifications and posts are announced on the app_events channel,
+-- the api listens to it and pushes them to the connected clients.
+-- the payload is kept small, listeners load the row themselves.
+CREATE OR REPLACE FUNCTION notify_app_event() RETURNS trigger AS $$
+BEGIN
+    PERFORM pg_notify(
+        'app_events',
+        json_build_object('type', TG_ARGV[0], 'id', NEW.id, 'user_id', NEW.user_id)::text
+    );
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS notifications_app_event ON notifications;
+CREATE TRIGGER notifications_app_event
+    AFTER INSERT ON notifications
+    FOR EACH ROW EXECUTE FUNCTION notify_app_event('notification');
+
+DROP TRIGGER IF EXISTS posts_app_event ON posts;
+CREATE TRIGGER posts_app_event
+    AFTER INSERT ON posts
+    FOR EACH ROW EXECUTE FUNCTION notify_app_event('post');
@@ -0,0 +1,28 @@
+-- one conversation per pair of users, user_a is always the smaller id so a pair only exists once
+CREATE TABLE IF NOT EXISTS conversations (
+    id bigserial PRIMARY KEY,
+    user_a bigint NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    user_b bigint NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    last_message_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    CHECK (user_a < user_b),
+    UNIQUE (user_a, user_b)
+);
+
+-- the inbox of a user, most recently active first
+CREATE INDEX IF NOT EXISTS idx_conversations_user_a ON conversations (user_a, last_message_at DESC, id DESC);
+
+CREATE INDEX IF NOT EXISTS idx_conversations_user_b ON conversations (user_b, last_message_at DESC, id DESC);
+
+CREATE TABLE IF NOT EXISTS messages (
+    id bigserial PRIMARY KEY,
+    conversation_id bigint NOT NULL REFERENCES conversations (id) ON DELETE CASCADE,
+    sender_id bigint NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    content TEXT NOT NULL,
+    read_at TIMESTAMP(0) WITH TIME ZONE, -- set when the recipient read it
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_messages_conversation_id_id ON messages (conversation_id, id);
+
+CREATE INDEX IF NOT EXISTS idx_messages_unread ON messages (conversation_id, sender_id) WHERE read_at IS NULL;
@@ -0,0 +1,16 @@
+-- images attached to posts, the files themselves live in the blob store under the keys.
+-- the urls are built from the keys when posts are read, so the blob store can move.
+CREATE TABLE IF NOT EXISTS attachments (
+    id bigserial PRIMARY KEY,
+    post_id bigint NOT NULL REFERENCES posts (id) ON DELETE CASCADE,
+    user_id bigint NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    blob_key TEXT NOT NULL,
+    thumbnail_key TEXT NOT NULL,
+    content_type VARCHAR(64) NOT NULL,
+    size bigint NOT NULL,
+    width int NOT NULL,
+    height int NOT NULL,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_attachments_post_id ON attachments (post_id, id);
@@ -0,0 +1,14 @@
+-- reset tokens are single use, we only keep the sha256 of them
+CREATE TABLE IF NOT EXISTS password_resets (
+    token_hash TEXT PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    expires_at TIMESTAMP(0) WITH TIME ZONE NOT NULL,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_password_resets_user_id ON password_resets (user_id);
+
+-- every access token carries the version it was issued with, changing the
+-- password bumps it and the older tokens stop working
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS token_version bigint NOT NULL DEFAULT 0;
@@ -0,0 +1,3 @@
+-- usernames are unique no matter the case, "Bob" and "bob" can't both exist.
+-- this fails if such pairs exist already, rename one of them first.
+CREATE UNIQUE INDEX IF NOT EXISTS users_username_lower_key ON users (lower(username));
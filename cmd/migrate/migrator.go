@@ -0,0 +1,389 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// lockKey is the postgres advisory lock we hold while migrating,
+// so two deploys can never run migrations at the same time
+const lockKey = 7_245_391_017
+
+// put this on the first line of a migration that can't run inside a transaction
+// (CREATE INDEX CONCURRENTLY for example)
+const noTransactionDirective = "-- migrate:no-transaction"
+
+var (
+	ErrDirty            = errors.New("database is dirty, fix it by hand and run force")
+	ErrChecksumMismatch = errors.New("applied migration was changed after it ran")
+	ErrUnknownVersion   = errors.New("unknown migration version")
+	fileNamePattern     = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+)
+
+type migration struct {
+	version  int64
+	name     string
+	up       string
+	down     string
+	checksum string
+}
+
+// appliedMigration is a row of the schema_migrations table
+type appliedMigration struct {
+	version   int64
+	name      string
+	checksum  string
+	dirty     bool
+	appliedAt time.Time
+}
+
+type migrator struct {
+	conn       *sql.Conn
+	migrations []migration
+}
+
+// loadMigrations reads every NNNNNN_name.(up|down).sql file of dir, sorted by version
+func loadMigrations(fsys fs.FS, dir string) ([]migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int64]*migration{}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		matches := fileNamePattern.FindStringSubmatch(entry.Name())
+		if matches == nil {
+			continue
+		}
+
+		version, err := strconv.ParseInt(matches[1], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		content, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &migration{version: version, name: matches[2]}
+			byVersion[version] = m
+		} else if m.name != matches[2] {
+			return nil, fmt.Errorf("migration %d has two names: %q and %q", version, m.name, matches[2])
+		}
+
+		switch matches[3] {
+		case "up":
+			m.up = string(content)
+			sum := sha256.Sum256(content)
+			m.checksum = hex.EncodeToString(sum[:])
+		case "down":
+			m.down = string(content)
+		}
+	}
+
+	migrations := make([]migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.up == "" || m.down == "" {
+			return nil, fmt.Errorf("migration %d_%s needs both an up and a down file", m.version, m.name)
+		}
+		migrations = append(migrations, *m)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].version < migrations[j].version
+	})
+
+	return migrations, nil
+}
+
+func newMigrator(ctx context.Context, db *sql.DB, migrations []migration) (*migrator, error) {
+	// advisory locks belong to a session, so everything has to run on one connection
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, lockKey); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	query := `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version bigint PRIMARY KEY,
+			name TEXT NOT NULL,
+			checksum TEXT NOT NULL,
+			dirty BOOLEAN NOT NULL DEFAULT FALSE,
+			applied_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW()
+		)
+	`
+	if _, err := conn.ExecContext(ctx, query); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return &migrator{conn: conn, migrations: migrations}, nil
+}
+
+func (m *migrator) Close() error {
+	_, err := m.conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, lockKey)
+	if closeErr := m.conn.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (m *migrator) applied(ctx context.Context) (map[int64]appliedMigration, error) {
+	rows, err := m.conn.QueryContext(ctx, `SELECT version, name, checksum, dirty, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int64]appliedMigration{}
+	for rows.Next() {
+		var a appliedMigration
+		if err := rows.Scan(&a.version, &a.name, &a.checksum, &a.dirty, &a.appliedAt); err != nil {
+			return nil, err
+		}
+		applied[a.version] = a
+	}
+
+	return applied, rows.Err()
+}
+
+// check refuses to do anything on a dirty database or when an applied file was edited
+func (m *migrator) check(ctx context.Context) (map[int64]appliedMigration, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, a := range applied {
+		if a.dirty {
+			return nil, fmt.Errorf("%w (version %d)", ErrDirty, a.version)
+		}
+	}
+
+	for _, mig := range m.migrations {
+		a, ok := applied[mig.version]
+		if ok && a.checksum != mig.checksum {
+			return nil, fmt.Errorf("%w: %d_%s", ErrChecksumMismatch, mig.version, mig.name)
+		}
+	}
+
+	return applied, nil
+}
+
+func (m *migrator) find(version int64) (migration, bool) {
+	for _, mig := range m.migrations {
+		if mig.version == version {
+			return mig, true
+		}
+	}
+	return migration{}, false
+}
+
+// Up applies every pending migration
+func (m *migrator) Up(ctx context.Context) error {
+	if len(m.migrations) == 0 {
+		return nil
+	}
+	return m.Goto(ctx, m.migrations[len(m.migrations)-1].version)
+}
+
+// Down rolls back the last n applied migrations
+func (m *migrator) Down(ctx context.Context, n int) error {
+	applied, err := m.check(ctx)
+	if err != nil {
+		return err
+	}
+
+	for i := len(m.migrations) - 1; i >= 0 && n > 0; i-- {
+		mig := m.migrations[i]
+		if _, ok := applied[mig.version]; !ok {
+			continue
+		}
+
+		if err := m.run(ctx, mig, false); err != nil {
+			return err
+		}
+		n--
+	}
+
+	return nil
+}
+
+// Goto migrates up or down until version is the last applied migration,
+// version 0 rolls everything back
+func (m *migrator) Goto(ctx context.Context, version int64) error {
+	if _, ok := m.find(version); !ok && version != 0 {
+		return fmt.Errorf("%w: %d", ErrUnknownVersion, version)
+	}
+
+	applied, err := m.check(ctx)
+	if err != nil {
+		return err
+	}
+
+	// roll back everything after version, newest first
+	for i := len(m.migrations) - 1; i >= 0; i-- {
+		mig := m.migrations[i]
+		if _, ok := applied[mig.version]; ok && mig.version > version {
+			if err := m.run(ctx, mig, false); err != nil {
+				return err
+			}
+		}
+	}
+
+	// then apply everything up to version, oldest first
+	for _, mig := range m.migrations {
+		if _, ok := applied[mig.version]; !ok && mig.version <= version {
+			if err := m.run(ctx, mig, true); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Force marks version as the last applied migration without running any sql.
+// it is the way out of a dirty database after fixing it by hand.
+func (m *migrator) Force(ctx context.Context, version int64) error {
+	if _, ok := m.find(version); !ok && version != 0 {
+		return fmt.Errorf("%w: %d", ErrUnknownVersion, version)
+	}
+
+	tx, err := m.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM schema_migrations WHERE version > $1`, version); err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO schema_migrations (version, name, checksum, dirty)
+		VALUES ($1, $2, $3, FALSE)
+		ON CONFLICT (version) DO UPDATE SET name = $2, checksum = $3, dirty = FALSE
+	`
+	for _, mig := range m.migrations {
+		if mig.version > version {
+			break
+		}
+		if _, err := tx.ExecContext(ctx, query, mig.version, mig.name, mig.checksum); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+type migrationStatus struct {
+	migration
+	applied   bool
+	dirty     bool
+	modified  bool
+	appliedAt time.Time
+}
+
+func (m *migrator) Status(ctx context.Context) ([]migrationStatus, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]migrationStatus, 0, len(m.migrations))
+	for _, mig := range m.migrations {
+		st := migrationStatus{migration: mig}
+		if a, ok := applied[mig.version]; ok {
+			st.applied = true
+			st.dirty = a.dirty
+			st.modified = a.checksum != mig.checksum
+			st.appliedAt = a.appliedAt
+		}
+		statuses = append(statuses, st)
+	}
+
+	return statuses, nil
+}
+
+// run applies (up = true) or rolls back a single migration and records it
+func (m *migrator) run(ctx context.Context, mig migration, up bool) error {
+	script := mig.down
+	if up {
+		script = mig.up
+	}
+
+	if strings.HasPrefix(strings.TrimSpace(script), noTransactionDirective) {
+		return m.runWithoutTransaction(ctx, mig, script, up)
+	}
+
+	tx, err := m.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		return fmt.Errorf("migration %d_%s failed: %w", mig.version, mig.name, err)
+	}
+
+	if up {
+		_, err = tx.ExecContext(ctx, `INSERT INTO schema_migrations (version, name, checksum) VALUES ($1, $2, $3)`, mig.version, mig.name, mig.checksum)
+	} else {
+		_, err = tx.ExecContext(ctx, `DELETE FROM schema_migrations WHERE version = $1`, mig.version)
+	}
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// runWithoutTransaction marks the migration dirty before running it,
+// if the script fails half way the database stays dirty until someone runs force
+func (m *migrator) runWithoutTransaction(ctx context.Context, mig migration, script string, up bool) error {
+	query := `
+		INSERT INTO schema_migrations (version, name, checksum, dirty)
+		VALUES ($1, $2, $3, TRUE)
+		ON CONFLICT (version) DO UPDATE SET dirty = TRUE
+	`
+	if _, err := m.conn.ExecContext(ctx, query, mig.version, mig.name, mig.checksum); err != nil {
+		return err
+	}
+
+	if _, err := m.conn.ExecContext(ctx, script); err != nil {
+		return fmt.Errorf("migration %d_%s failed, database is now dirty: %w", mig.version, mig.name, err)
+	}
+
+	var err error
+	if up {
+		_, err = m.conn.ExecContext(ctx, `UPDATE schema_migrations SET dirty = FALSE WHERE version = $1`, mig.version)
+	} else {
+		_, err = m.conn.ExecContext(ctx, `DELETE FROM schema_migrations WHERE version = $1`, mig.version)
+	}
+
+	return err
+}
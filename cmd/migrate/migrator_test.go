@@ -0,0 +1,125 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	fsys := fstest.MapFS{
+		"migrations/000002_add_posts.up.sql":   {Data: []byte("CREATE TABLE posts ();")},
+		"migrations/000002_add_posts.down.sql": {Data: []byte("DROP TABLE posts;")},
+		"migrations/000001_init.up.sql":        {Data: []byte("CREATE TABLE users ();")},
+		"migrations/000001_init.down.sql":      {Data: []byte("DROP TABLE users;")},
+		"migrations/README.md":                 {Data: []byte("not a migration")},
+		"migrations/old/000003_x.up.sql":       {Data: []byte("ignored, it is in a sub directory")},
+	}
+
+	migrations, err := loadMigrations(fsys, "migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(migrations) != 2 {
+		t.Fatalf("got %d migrations, want 2", len(migrations))
+	}
+
+	first, second := migrations[0], migrations[1]
+	if first.version != 1 || first.name != "init" || second.version != 2 || second.name != "add_posts" {
+		t.Errorf("got %d_%s and %d_%s, want them sorted by version", first.version, first.name, second.version, second.name)
+	}
+	if first.up != "CREATE TABLE users ();" || first.down != "DROP TABLE users;" {
+		t.Errorf("first = %q / %q", first.up, first.down)
+	}
+
+	// the checksum is over the up file only
+	sum := sha256.Sum256([]byte("CREATE TABLE users ();"))
+	if want := hex.EncodeToString(sum[:]); first.checksum != want {
+		t.Errorf("checksum = %s, want %s", first.checksum, want)
+	}
+}
+
+func TestLoadMigrationsChecksumChanges(t *testing.T) {
+	load := func(up, down string) string {
+		t.Helper()
+
+		migrations, err := loadMigrations(fstest.MapFS{
+			"m/000001_init.up.sql":   {Data: []byte(up)},
+			"m/000001_init.down.sql": {Data: []byte(down)},
+		}, "m")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return migrations[0].checksum
+	}
+
+	original := load("CREATE TABLE users ();", "DROP TABLE users;")
+
+	if got := load("CREATE TABLE users ();", "DROP TABLE users;"); got != original {
+		t.Error("the same files gave a different checksum")
+	}
+	if got := load("CREATE TABLE users (id int);", "DROP TABLE users;"); got == original {
+		t.Error("changing the up file didn't change the checksum")
+	}
+	if got := load("CREATE TABLE users ();", "DROP TABLE IF EXISTS users;"); got != original {
+		t.Error("changing the down file changed the checksum")
+	}
+}
+
+func TestLoadMigrationsErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		fsys fstest.MapFS
+		want string
+	}{
+		{
+			name: "missing down",
+			fsys: fstest.MapFS{
+				"m/000001_init.up.sql": {Data: []byte("SELECT 1;")},
+			},
+			want: "needs both an up and a down file",
+		},
+		{
+			name: "missing up",
+			fsys: fstest.MapFS{
+				"m/000001_init.down.sql": {Data: []byte("SELECT 1;")},
+			},
+			want: "needs both an up and a down file",
+		},
+		{
+			name: "two names for one version",
+			fsys: fstest.MapFS{
+				"m/000001_init.up.sql":    {Data: []byte("SELECT 1;")},
+				"m/000001_other.down.sql": {Data: []byte("SELECT 1;")},
+			},
+			want: "has two names",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadMigrations(tt.fsys, "m")
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("err = %v, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
+
+// the migrations we ship have to load, or the deploy fails
+func TestLoadRepoMigrations(t *testing.T) {
+	migrations, err := loadMigrations(os.DirFS("."), "migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, m := range migrations {
+		if m.version != int64(i+1) {
+			t.Errorf("migration %d_%s, want version %d, versions must not have gaps", m.version, m.name, i+1)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// the middleware uses these to tell the client what went wrong,
+// every error ValidateToken returns wraps one of them
+var (
+	ErrTokenExpired     = errors.New("token is expired")
+	ErrTokenNotValidYet = errors.New("token is not valid yet")
+	ErrTokenInvalid     = errors.New("token is invalid")
+)
+
+// Claims are the claims of our access tokens, sub is the user id as a string
+type Claims struct {
+	jwt.StandardClaims
+	// the token version of the user when the token was issued, a password change bumps it
+	Version int64 `json:"ver"`
+}
+
+func NewClaims(userID, version int64, jti, aud, iss string, now time.Time, ttl time.Duration) *Claims {
+	return &Claims{
+		Version: version,
+		StandardClaims: jwt.StandardClaims{
+			Subject:   strconv.FormatInt(userID, 10),
+			Id:        jti,
+			Audience:  aud,
+			Issuer:    iss,
+			IssuedAt:  now.Unix(),
+			NotBefore: now.Unix(),
+			ExpiresAt: now.Add(ttl).Unix(),
+		},
+	}
+}
+
+func (c *Claims) UserID() (int64, error) {
+	id, err := strconv.ParseInt(c.Subject, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: bad subject %q", ErrTokenInvalid, c.Subject)
+	}
+	return id, nil
+}
+
+// claimsValidator checks the registered claims, leeway covers clock skew between servers
+type claimsValidator struct {
+	aud    string
+	iss    string
+	leeway time.Duration
+	now    func() time.Time
+}
+
+func newClaimsValidator(aud, iss string, leeway time.Duration) claimsValidator {
+	return claimsValidator{aud: aud, iss: iss, leeway: leeway, now: time.Now}
+}
+
+// parse verifies the signature with keyFunc and then our own claim rules,
+// the parser's built in claim checks have no leeway so we skip them
+func (v claimsValidator) parse(parser *jwt.Parser, token string, keyFunc jwt.Keyfunc) (*jwt.Token, error) {
+	parser.SkipClaimsValidation = true
+
+	t, err := parser.ParseWithClaims(token, &Claims{}, keyFunc)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrTokenInvalid, err)
+	}
+
+	claims, ok := t.Claims.(*Claims)
+	if !ok {
+		return nil, fmt.Errorf("%w: unexpected claims", ErrTokenInvalid)
+	}
+
+	if err := v.validate(claims); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+func (v claimsValidator) validate(c *Claims) error {
+	now := v.now()
+
+	if c.ExpiresAt == 0 {
+		return fmt.Errorf("%w: exp is required", ErrTokenInvalid)
+	}
+	if now.After(time.Unix(c.ExpiresAt, 0).Add(v.leeway)) {
+		return ErrTokenExpired
+	}
+
+	if c.NotBefore != 0 && now.Add(v.leeway).Before(time.Unix(c.NotBefore, 0)) {
+		return ErrTokenNotValidYet
+	}
+
+	if c.Audience != v.aud {
+		return fmt.Errorf("%w: unexpected audience %q", ErrTokenInvalid, c.Audience)
+	}
+
+	if c.Issuer != v.iss {
+		return fmt.Errorf("%w: unexpected issuer %q", ErrTokenInvalid, c.Issuer)
+	}
+
+	if c.Subject == "" || c.Id == "" {
+		return fmt.Errorf("%w: sub and jti are required", ErrTokenInvalid)
+	}
+
+	return nil
+}
@@ -0,0 +1,112 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+func TestJWTAuthenticatorClaims(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	leeway := time.Second * 30
+
+	a := NewJWTAuthenticator("secret", "gophersocial", "gophersocial-api", leeway)
+	a.validator.now = func() time.Time { return now }
+
+	valid := func() *Claims {
+		return NewClaims(1, 0, "jti", "gophersocial", "gophersocial-api", now.Add(-time.Minute), time.Hour)
+	}
+
+	tests := []struct {
+		name   string
+		change func(c *Claims)
+		want   error // nil means accepted
+	}{
+		{"valid", func(c *Claims) {}, nil},
+		{"wrong audience", func(c *Claims) { c.Audience = "other-app" }, ErrTokenInvalid},
+		{"no audience", func(c *Claims) { c.Audience = "" }, ErrTokenInvalid},
+		{"wrong issuer", func(c *Claims) { c.Issuer = "someone-else" }, ErrTokenInvalid},
+		{"no exp", func(c *Claims) { c.ExpiresAt = 0 }, ErrTokenInvalid},
+		{"expired inside the leeway", func(c *Claims) { c.ExpiresAt = now.Add(-leeway).Unix() }, nil},
+		{"expired outside the leeway", func(c *Claims) { c.ExpiresAt = now.Add(-leeway - time.Second).Unix() }, ErrTokenExpired},
+		{"nbf inside the leeway", func(c *Claims) { c.NotBefore = now.Add(leeway).Unix() }, nil},
+		{"nbf in the future", func(c *Claims) { c.NotBefore = now.Add(leeway + time.Second).Unix() }, ErrTokenNotValidYet},
+		{"no nbf", func(c *Claims) { c.NotBefore = 0 }, nil},
+		{"no subject", func(c *Claims) { c.Subject = "" }, ErrTokenInvalid},
+		{"no jti", func(c *Claims) { c.Id = "" }, ErrTokenInvalid},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := valid()
+			tt.change(claims)
+
+			token, err := a.GenerateToken(claims)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = a.ValidateToken(token)
+			if tt.want == nil {
+				if err != nil {
+					t.Errorf("err = %v, want the token accepted", err)
+				}
+				return
+			}
+
+			if !errors.Is(err, tt.want) {
+				t.Errorf("err = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestJWTAuthenticatorSignature(t *testing.T) {
+	now := time.Now()
+	a := NewJWTAuthenticator("secret", "aud", "iss", 0)
+	claims := NewClaims(1, 0, "jti", "aud", "iss", now, time.Hour)
+
+	tests := []struct {
+		name  string
+		token func() (string, error)
+	}{
+		{"other secret", func() (string, error) {
+			return NewJWTAuthenticator("other", "aud", "iss", 0).GenerateToken(claims)
+		}},
+		{"alg none", func() (string, error) {
+			return jwt.NewWithClaims(jwt.SigningMethodNone, claims).SignedString(jwt.UnsafeAllowNoneSignatureType)
+		}},
+		{"tampered", func() (string, error) {
+			token, err := a.GenerateToken(claims)
+			return token[:len(token)-2] + "xx", err
+		}},
+		{"garbage", func() (string, error) { return "not.a.token", nil }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := tt.token()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := a.ValidateToken(token); !errors.Is(err, ErrTokenInvalid) {
+				t.Errorf("err = %v, want ErrTokenInvalid", err)
+			}
+		})
+	}
+}
+
+func TestClaimsUserID(t *testing.T) {
+	id, err := NewClaims(42, 0, "jti", "aud", "iss", time.Now(), time.Hour).UserID()
+	if err != nil || id != 42 {
+		t.Errorf("UserID = %d, %v, want 42", id, err)
+	}
+
+	bad := &Claims{StandardClaims: jwt.StandardClaims{Subject: "bob"}}
+	if _, err := bad.UserID(); !errors.Is(err, ErrTokenInvalid) {
+		t.Errorf("err = %v, want ErrTokenInvalid", err)
+	}
+}
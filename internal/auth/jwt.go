@@ -2,22 +2,24 @@ package auth
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/golang-jwt/jwt"
 )
 
+// JWTAuthenticator signs tokens with a shared HS256 secret,
+// use KeySetAuthenticator when other services have to verify our tokens
 type JWTAuthenticator struct {
-	secret string
-	aud    string
-	iss    string
+	secret    string
+	validator claimsValidator
 }
 
-func NewJWTAuthenticator(secret, aud, iss string) *JWTAuthenticator {
-	return &JWTAuthenticator{secret: secret, aud: aud, iss: iss}
+func NewJWTAuthenticator(secret, aud, iss string, leeway time.Duration) *JWTAuthenticator {
+	return &JWTAuthenticator{secret: secret, validator: newClaimsValidator(aud, iss, leeway)}
 }
 
 func (a *JWTAuthenticator) GenerateToken(claims jwt.Claims) (string, error) {
-	token := jwt.NewWithClaims(&jwt.SigningMethodHMAC{}, claims)
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	tokenString, err := token.SignedString([]byte(a.secret))
 	if err != nil {
 		return "", err
@@ -26,18 +28,15 @@ func (a *JWTAuthenticator) GenerateToken(claims jwt.Claims) (string, error) {
 	return tokenString, nil
 }
 
+// ValidateToken returns a token whose Claims are *Claims
 func (a *JWTAuthenticator) ValidateToken(token string) (*jwt.Token, error) {
-	return jwt.Parse(token, func(t *jwt.Token) (any, error) {
+	parser := &jwt.Parser{ValidMethods: []string{jwt.SigningMethodHS256.Name}}
+
+	return a.validator.parse(parser, token, func(t *jwt.Token) (any, error) {
 		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, fmt.Errorf("unexpected signing method %v", t.Header["alg"])
 		}
 
 		return []byte(a.secret), nil
-	},
-	// jwt.WithExpirationRequired(),
-	// jwt.WithAudience(a.aud),
-	// jwt.WithIssuer(a.aud),
-	// jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Name}),
-	)
-
+	})
 }
@@ -0,0 +1,192 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+var (
+	ErrNoSigningKey = errors.New("no active signing key")
+	ErrUnknownKey   = errors.New("unknown or retired key")
+)
+
+// SigningKey is one of our asymmetric keys, ID is the "kid" in the token header
+type SigningKey struct {
+	ID         string
+	Method     jwt.SigningMethod
+	Private    crypto.PrivateKey
+	Public     crypto.PublicKey
+	ActiveFrom time.Time // we start signing with it at this time
+	RetireAt   time.Time // after this tokens signed by it are rejected, zero means never
+}
+
+// KeySet holds every key we know about. the newest active key signs,
+// every key that is not retired yet still verifies so rotating doesn't log anyone out.
+type KeySet struct {
+	keys []*SigningKey // sorted by ActiveFrom, oldest first
+	now  func() time.Time
+}
+
+// keyManifest is one entry of keys.json, it is the rotation schedule:
+//
+//	[{"kid": "2026-10", "file": "2026-10.pem", "active_from": "2026-10-01T00:00:00Z", "retire_at": "2027-01-15T00:00:00Z"}]
+//
+// add the next key ahead of time so other services see it in the jwks before we sign with it,
+// and only retire a key after the last token it signed has expired.
+type keyManifest struct {
+	ID         string    `json:"kid"`
+	File       string    `json:"file"`
+	ActiveFrom time.Time `json:"active_from"`
+	RetireAt   time.Time `json:"retire_at"`
+}
+
+// LoadKeySet reads dir/keys.json and the PEM encoded private keys it points to
+func LoadKeySet(dir string) (*KeySet, error) {
+	data, err := os.ReadFile(filepath.Join(dir, "keys.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var manifest []keyManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("keys.json: %w", err)
+	}
+
+	keys := make([]*SigningKey, 0, len(manifest))
+	seen := map[string]bool{}
+	for _, m := range manifest {
+		if m.ID == "" || seen[m.ID] {
+			return nil, fmt.Errorf("keys.json: empty or duplicated kid %q", m.ID)
+		}
+		seen[m.ID] = true
+
+		pemData, err := os.ReadFile(filepath.Join(dir, m.File))
+		if err != nil {
+			return nil, err
+		}
+
+		key, err := parsePrivateKey(pemData)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", m.ID, err)
+		}
+
+		key.ID = m.ID
+		key.ActiveFrom = m.ActiveFrom
+		key.RetireAt = m.RetireAt
+		keys = append(keys, key)
+	}
+
+	return NewKeySet(keys...), nil
+}
+
+func NewKeySet(keys ...*SigningKey) *KeySet {
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].ActiveFrom.Before(keys[j].ActiveFrom)
+	})
+
+	return &KeySet{keys: keys, now: time.Now}
+}
+
+// Signing returns the newest key that is already active and not retired
+func (ks *KeySet) Signing() (*SigningKey, error) {
+	now := ks.now()
+	for i := len(ks.keys) - 1; i >= 0; i-- {
+		key := ks.keys[i]
+		if !key.ActiveFrom.After(now) && !key.retired(now) {
+			return key, nil
+		}
+	}
+
+	return nil, ErrNoSigningKey
+}
+
+// Verifying returns the key with this kid as long as it is not retired
+func (ks *KeySet) Verifying(kid string) (*SigningKey, error) {
+	now := ks.now()
+	for _, key := range ks.keys {
+		if key.ID == kid && !key.retired(now) {
+			return key, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w: %q", ErrUnknownKey, kid)
+}
+
+// Published returns every key that is not retired, including the ones
+// that are not active yet, those are the keys other services must trust
+func (ks *KeySet) Published() []*SigningKey {
+	now := ks.now()
+	keys := make([]*SigningKey, 0, len(ks.keys))
+	for _, key := range ks.keys {
+		if !key.retired(now) {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys
+}
+
+// Methods returns the names of the algorithms our keys use
+func (ks *KeySet) Methods() []string {
+	seen := map[string]bool{}
+	var methods []string
+	for _, key := range ks.keys {
+		alg := key.Method.Alg()
+		if !seen[alg] {
+			seen[alg] = true
+			methods = append(methods, alg)
+		}
+	}
+
+	return methods
+}
+
+func (k *SigningKey) retired(now time.Time) bool {
+	return !k.RetireAt.IsZero() && !now.Before(k.RetireAt)
+}
+
+// parsePrivateKey supports PKCS8 ("PRIVATE KEY") RSA and Ed25519 keys and PKCS1 ("RSA PRIVATE KEY")
+func parsePrivateKey(data []byte) (*SigningKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	var private any
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		private, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		private, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch key := private.(type) {
+	case *rsa.PrivateKey:
+		if key.N.BitLen() < 2048 {
+			return nil, errors.New("rsa keys must have at least 2048 bits")
+		}
+		return &SigningKey{Method: jwt.SigningMethodRS256, Private: key, Public: &key.PublicKey}, nil
+	case ed25519.PrivateKey:
+		return &SigningKey{Method: jwt.SigningMethodEdDSA, Private: key, Public: key.Public()}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", private)
+	}
+}
@@ -0,0 +1,363 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// generating rsa keys is slow, every test shares the same one
+var (
+	rsaOnce sync.Once
+	rsaKey  *rsa.PrivateKey
+)
+
+func testRSAKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+
+	rsaOnce.Do(func() {
+		var err error
+		if rsaKey, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+			t.Fatal(err)
+		}
+	})
+	return rsaKey
+}
+
+func testEd25519Key(t *testing.T) ed25519.PrivateKey {
+	t.Helper()
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func pkcs8PEM(t *testing.T, key any) []byte {
+	t.Helper()
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+// writeKeyDir writes keys.json and the files next to it into a new directory
+func writeKeyDir(t *testing.T, manifest string, files map[string][]byte) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	files["keys.json"] = []byte(manifest)
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+var (
+	jan = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	feb = time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)
+	mar = time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	apr = time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC)
+)
+
+// rotation is the schedule the tests run against: "jan" signs until "feb" is active
+// and verifies until april, "mar" is published ahead of time. the list is out of order on purpose.
+func rotation(t *testing.T) []*SigningKey {
+	t.Helper()
+
+	feb25519, mar25519 := testEd25519Key(t), testEd25519Key(t)
+	return []*SigningKey{
+		{ID: "mar", Method: jwt.SigningMethodEdDSA, Private: mar25519, Public: mar25519.Public(), ActiveFrom: mar},
+		{ID: "jan", Method: jwt.SigningMethodRS256, Private: testRSAKey(t), Public: &testRSAKey(t).PublicKey, ActiveFrom: jan, RetireAt: apr},
+		{ID: "feb", Method: jwt.SigningMethodEdDSA, Private: feb25519, Public: feb25519.Public(), ActiveFrom: feb},
+	}
+}
+
+func keySetAt(t *testing.T, now time.Time) *KeySet {
+	t.Helper()
+
+	ks := NewKeySet(rotation(t)...)
+	ks.now = func() time.Time { return now }
+	return ks
+}
+
+func TestLoadKeySet(t *testing.T) {
+	rsaPKCS1 := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(testRSAKey(t))})
+
+	dir := writeKeyDir(t, `[
+		{"kid": "new", "file": "new.pem", "active_from": "2026-02-01T00:00:00Z"},
+		{"kid": "old", "file": "old.pem", "active_from": "2026-01-01T00:00:00Z", "retire_at": "2026-04-01T00:00:00Z"},
+		{"kid": "pkcs8", "file": "pkcs8.pem", "active_from": "2026-03-01T00:00:00Z"}
+	]`, map[string][]byte{
+		"old.pem":   rsaPKCS1,
+		"new.pem":   pkcs8PEM(t, testEd25519Key(t)),
+		"pkcs8.pem": pkcs8PEM(t, testRSAKey(t)),
+	})
+
+	ks, err := LoadKeySet(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		kid string
+		alg string
+	}{{"old", "RS256"}, {"new", "EdDSA"}, {"pkcs8", "RS256"}}
+	if len(ks.keys) != len(want) {
+		t.Fatalf("got %d keys, want %d", len(ks.keys), len(want))
+	}
+	for i, w := range want {
+		if key := ks.keys[i]; key.ID != w.kid || key.Method.Alg() != w.alg {
+			t.Errorf("key %d = %s/%s, want %s/%s sorted by active_from", i, key.ID, key.Method.Alg(), w.kid, w.alg)
+		}
+	}
+	if !ks.keys[0].RetireAt.Equal(apr) || !ks.keys[1].RetireAt.IsZero() {
+		t.Errorf("retire_at = %v and %v", ks.keys[0].RetireAt, ks.keys[1].RetireAt)
+	}
+
+	if methods := strings.Join(ks.Methods(), ","); methods != "RS256,EdDSA" {
+		t.Errorf("methods = %s, want RS256,EdDSA", methods)
+	}
+}
+
+func TestLoadKeySetErrors(t *testing.T) {
+	good := pkcs8PEM(t, testEd25519Key(t))
+
+	smallRSA, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		manifest string
+		files    map[string][]byte
+		want     string
+	}{
+		{"malformed json", `[{"kid": "a",`, nil, "keys.json"},
+		{"not a list", `{"kid": "a"}`, nil, "keys.json"},
+		{"bad time", `[{"kid": "a", "file": "a.pem", "active_from": "tomorrow"}]`, map[string][]byte{"a.pem": good}, "keys.json"},
+		{"empty kid", `[{"kid": "", "file": "a.pem"}]`, map[string][]byte{"a.pem": good}, "empty or duplicated kid"},
+		{"duplicated kid", `[{"kid": "a", "file": "a.pem"}, {"kid": "a", "file": "a.pem"}]`, map[string][]byte{"a.pem": good}, "empty or duplicated kid"},
+		{"missing file", `[{"kid": "a", "file": "missing.pem"}]`, nil, "no such file"},
+		{"not pem", `[{"kid": "a", "file": "a.pem"}]`, map[string][]byte{"a.pem": []byte("hello")}, "no PEM block"},
+		{"public key", `[{"kid": "a", "file": "a.pem"}]`, map[string][]byte{"a.pem": pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: []byte{1}})}, "unsupported PEM block"},
+		{"broken der", `[{"kid": "a", "file": "a.pem"}]`, map[string][]byte{"a.pem": pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: []byte{1, 2, 3}})}, "key a"},
+		{"small rsa", `[{"kid": "a", "file": "a.pem"}]`, map[string][]byte{"a.pem": pkcs8PEM(t, smallRSA)}, "at least 2048 bits"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := tt.files
+			if files == nil {
+				files = map[string][]byte{}
+			}
+
+			_, err := LoadKeySet(writeKeyDir(t, tt.manifest, files))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("err = %v, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+
+	if _, err := LoadKeySet(t.TempDir()); err == nil {
+		t.Error("a directory without keys.json loaded")
+	}
+}
+
+func TestKeySetSigning(t *testing.T) {
+	tests := []struct {
+		name string
+		now  time.Time
+		want string // "" means no key
+	}{
+		{"before the first key", jan.Add(-time.Second), ""},
+		{"first key", jan, "jan"},
+		{"newest active key wins", feb.Add(time.Hour), "feb"},
+		{"published keys don't sign yet", mar.Add(-time.Second), "feb"},
+		{"next key in the schedule", mar, "mar"},
+		{"after the old key retired", apr, "mar"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := keySetAt(t, tt.now).Signing()
+			if tt.want == "" {
+				if !errors.Is(err, ErrNoSigningKey) {
+					t.Errorf("Signing = %v, %v, want ErrNoSigningKey", key, err)
+				}
+				return
+			}
+
+			if err != nil || key.ID != tt.want {
+				t.Errorf("Signing = %v, %v, want %s", key, err, tt.want)
+			}
+		})
+	}
+
+	// a key that is already retired never signs, even if it is the only one
+	ks := NewKeySet(&SigningKey{ID: "gone", Method: jwt.SigningMethodRS256, ActiveFrom: jan, RetireAt: feb})
+	ks.now = func() time.Time { return mar }
+	if _, err := ks.Signing(); !errors.Is(err, ErrNoSigningKey) {
+		t.Errorf("retired only key: err = %v, want ErrNoSigningKey", err)
+	}
+}
+
+func TestKeySetVerifying(t *testing.T) {
+	tests := []struct {
+		name string
+		now  time.Time
+		kid  string
+		ok   bool
+	}{
+		{"active key", feb, "feb", true},
+		{"old key before it retires", apr.Add(-time.Second), "jan", true},
+		{"old key once it retired", apr, "jan", false},
+		{"key that doesn't sign yet", feb, "mar", true},
+		{"unknown kid", feb, "nope", false},
+		{"no kid", feb, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := keySetAt(t, tt.now).Verifying(tt.kid)
+			if tt.ok {
+				if err != nil || key.ID != tt.kid {
+					t.Errorf("Verifying(%q) = %v, %v, want the key", tt.kid, key, err)
+				}
+				return
+			}
+
+			if !errors.Is(err, ErrUnknownKey) {
+				t.Errorf("Verifying(%q) = %v, %v, want ErrUnknownKey", tt.kid, key, err)
+			}
+		})
+	}
+}
+
+func TestKeySetPublished(t *testing.T) {
+	ids := func(now time.Time) string {
+		var kids []string
+		for _, key := range keySetAt(t, now).Published() {
+			kids = append(kids, key.ID)
+		}
+		return strings.Join(kids, ",")
+	}
+
+	if got := ids(feb); got != "jan,feb,mar" {
+		t.Errorf("published in february = %s, want the upcoming key too", got)
+	}
+	if got := ids(apr); got != "feb,mar" {
+		t.Errorf("published in april = %s, want the retired key left out", got)
+	}
+
+	set := keySetAt(t, apr).JWKS()
+	if len(set.Keys) != 2 {
+		t.Fatalf("jwks has %d keys, want 2", len(set.Keys))
+	}
+	for _, jwk := range set.Keys {
+		if jwk.Kid == "jan" {
+			t.Error("the retired key is in the jwks")
+		}
+		if jwk.Kty != "OKP" || jwk.Crv != "Ed25519" || jwk.Alg != "EdDSA" || jwk.X == "" {
+			t.Errorf("jwk = %+v", jwk)
+		}
+	}
+
+	rsaJWK := keySetAt(t, feb).JWKS().Keys[0]
+	if rsaJWK.Kid != "jan" || rsaJWK.Kty != "RSA" || rsaJWK.E != "AQAB" || rsaJWK.N == "" {
+		t.Errorf("rsa jwk = %+v", rsaJWK)
+	}
+}
+
+func TestKeySetAuthenticatorRotation(t *testing.T) {
+	now := jan.Add(time.Hour)
+	ks := NewKeySet(rotation(t)...)
+	ks.now = func() time.Time { return now }
+
+	a := NewKeySetAuthenticator(ks, "aud", "iss", 0)
+	a.validator.now = func() time.Time { return now }
+
+	sign := func() string {
+		t.Helper()
+
+		token, err := a.GenerateToken(NewClaims(1, 0, "jti", "aud", "iss", now, 365*24*time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+
+	old := sign()
+
+	// the next key signs from february, tokens of the old one still work
+	now = feb.Add(time.Hour)
+	current := sign()
+
+	for name, token := range map[string]string{"old": old, "current": current} {
+		if _, err := a.ValidateToken(token); err != nil {
+			t.Errorf("%s token: %v", name, err)
+		}
+	}
+
+	parsed, _ := a.ValidateToken(current)
+	if kid := parsed.Header["kid"]; kid != "feb" {
+		t.Errorf("kid = %v, want feb", kid)
+	}
+
+	// once the old key retired its tokens are rejected
+	now = apr
+	if _, err := a.ValidateToken(old); !errors.Is(err, ErrTokenInvalid) {
+		t.Errorf("token of a retired key: err = %v, want ErrTokenInvalid", err)
+	}
+	if _, err := a.ValidateToken(current); err != nil {
+		t.Errorf("current token after the rotation: %v", err)
+	}
+}
+
+func TestKeySetAuthenticatorRejectsForgedAlgorithms(t *testing.T) {
+	now := feb
+	ks := keySetAt(t, now)
+	a := NewKeySetAuthenticator(ks, "aud", "iss", 0)
+	a.validator.now = func() time.Time { return now }
+
+	claims := NewClaims(1, 0, "jti", "aud", "iss", now, time.Hour)
+
+	// the "jan" key is rsa, a token claiming it but signed with eddsa is forged
+	forged := jwt.NewWithClaims(jwt.SigningMethodEdDSA, claims)
+	forged.Header["kid"] = "jan"
+	token, err := forged.SignedString(ks.keys[1].Private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.ValidateToken(token); !errors.Is(err, ErrTokenInvalid) {
+		t.Errorf("eddsa token for an rsa key: err = %v, want ErrTokenInvalid", err)
+	}
+
+	// hs256 with the public key as the secret is the classic confusion attack
+	hs := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	hs.Header["kid"] = "feb"
+	token, err = hs.SignedString([]byte(ks.keys[1].Public.(ed25519.PublicKey)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.ValidateToken(token); !errors.Is(err, ErrTokenInvalid) {
+		t.Errorf("hs256 token: err = %v, want ErrTokenInvalid", err)
+	}
+}
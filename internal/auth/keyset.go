@@ -0,0 +1,56 @@
+package auth
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// KeySetAuthenticator signs tokens with RS256 or EdDSA keys from a KeySet,
+// other services only need our jwks to verify them
+type KeySetAuthenticator struct {
+	keys      *KeySet
+	validator claimsValidator
+}
+
+func NewKeySetAuthenticator(keys *KeySet, aud, iss string, leeway time.Duration) *KeySetAuthenticator {
+	return &KeySetAuthenticator{keys: keys, validator: newClaimsValidator(aud, iss, leeway)}
+}
+
+func (a *KeySetAuthenticator) GenerateToken(claims jwt.Claims) (string, error) {
+	key, err := a.keys.Signing()
+	if err != nil {
+		return "", err
+	}
+
+	token := jwt.NewWithClaims(key.Method, claims)
+	// verifiers pick the public key by kid
+	token.Header["kid"] = key.ID
+
+	return token.SignedString(key.Private)
+}
+
+// ValidateToken returns a token whose Claims are *Claims
+func (a *KeySetAuthenticator) ValidateToken(token string) (*jwt.Token, error) {
+	parser := &jwt.Parser{ValidMethods: a.keys.Methods()}
+
+	return a.validator.parse(parser, token, func(t *jwt.Token) (any, error) {
+		kid, _ := t.Header["kid"].(string)
+		key, err := a.keys.Verifying(kid)
+		if err != nil {
+			return nil, err
+		}
+
+		// a token signed with another algorithm than the key's is forged
+		if t.Method.Alg() != key.Method.Alg() {
+			return nil, fmt.Errorf("unexpected signing method %v for key %s", t.Header["alg"], kid)
+		}
+
+		return key.Public, nil
+	})
+}
+
+func (a *KeySetAuthenticator) JWKS() JWKSet {
+	return a.keys.JWKS()
+}
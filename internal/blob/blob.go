@@ -0,0 +1,40 @@
+// Package blob stores the files users upload, on the local disk or in an S3 compatible bucket
+package blob
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+)
+
+var (
+	ErrNotFound   = errors.New("blob not found")
+	ErrInvalidKey = errors.New("invalid blob key")
+)
+
+// BlobStore keeps blobs under slash separated keys like "posts/12/photo.jpg"
+type BlobStore interface {
+	Put(ctx context.Context, key string, data []byte, contentType string) error
+	// Get returns ErrNotFound for keys that don't exist, the caller closes the reader
+	Get(ctx context.Context, key string) (io.ReadCloser, string, error)
+	// Delete doesn't fail for keys that don't exist
+	Delete(ctx context.Context, key string) error
+	// URL is where clients can download the blob from
+	URL(key string) string
+}
+
+// validKey rejects keys that could escape the directory or bucket prefix they are meant for
+func validKey(key string) bool {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return false
+	}
+
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,82 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLocalStorePutGetDelete(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewLocalStore(dir, "http://localhost:4000/v1/media/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	key := "posts/1/a.png"
+	if err := s.Put(ctx, key, []byte("image"), "image/png"); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "posts", "1", "a.png")); err != nil {
+		t.Fatalf("put didn't write the file: %v", err)
+	}
+
+	body, contentType, err := s.Get(ctx, key)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	data, _ := io.ReadAll(body)
+	body.Close()
+	if string(data) != "image" || contentType != "image/png" {
+		t.Errorf("get = %q, %q, want %q, %q", data, contentType, "image", "image/png")
+	}
+
+	if got, want := s.URL(key), "http://localhost:4000/v1/media/posts/1/a.png"; got != want {
+		t.Errorf("URL = %q, want %q", got, want)
+	}
+
+	if err := s.Delete(ctx, key); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if _, _, err := s.Get(ctx, key); !errors.Is(err, ErrNotFound) {
+		t.Errorf("get after delete: err = %v, want ErrNotFound", err)
+	}
+	if err := s.Delete(ctx, key); err != nil {
+		t.Errorf("second delete: %v", err)
+	}
+
+	// nothing is left behind from the temp files
+	entries, _ := os.ReadDir(filepath.Join(dir, "posts", "1"))
+	if len(entries) != 0 {
+		t.Errorf("files left in the directory: %v", entries)
+	}
+}
+
+func TestLocalStoreRejectsBadKeys(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewLocalStore(filepath.Join(dir, "uploads"), "http://localhost/media")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	for _, key := range []string{"", "/etc/passwd", "../outside.jpg", "posts/../../outside.jpg", "posts//a.jpg", `posts\a.jpg`, "posts/./a.jpg"} {
+		if err := s.Put(ctx, key, []byte("x"), "image/jpeg"); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("put %q: err = %v, want ErrInvalidKey", key, err)
+		}
+		if _, _, err := s.Get(ctx, key); !errors.Is(err, ErrNotFound) {
+			t.Errorf("get %q: err = %v, want ErrNotFound", key, err)
+		}
+		if err := s.Delete(ctx, key); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("delete %q: err = %v, want ErrInvalidKey", key, err)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "outside.jpg")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("a file was written outside the store: %v", err)
+	}
+}
@@ -0,0 +1,243 @@
+// Package chat keeps the websocket connections of direct messages. it only moves
+// frames around, what a frame means is up to the Handler given to Serve.
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/sirUnchained/udemy-backend-course/internal/store"
+)
+
+const (
+	FrameMessage = "message" // client: send Content to To, server: a new message
+	FrameRead    = "read"    // client: read ConversationID up to MessageID, server: a read receipt
+	FrameError   = "error"
+
+	writeWait    = time.Second * 10
+	pongWait     = time.Second * 60
+	pingPeriod   = pongWait * 9 / 10 // must be shorter than pongWait
+	maxFrameSize = 8 * 1024
+	sendBuffer   = 16
+)
+
+// Frame is what goes over the socket both ways, as json.
+// Ref is picked by the client and echoed back on the answer to its frame.
+type Frame struct {
+	Type string `json:"type"`
+	Ref  string `json:"ref,omitempty"`
+
+	To             int64  `json:"to,omitempty"`
+	Content        string `json:"content,omitempty"`
+	ConversationID int64  `json:"conversation_id,omitempty"`
+	MessageID      int64  `json:"message_id,omitempty"`
+
+	Message *store.Message     `json:"message,omitempty"`
+	Receipt *store.ReadReceipt `json:"receipt,omitempty"`
+	Error   string             `json:"error,omitempty"`
+}
+
+// Handler is called for every frame a client sends, one at a time per connection
+type Handler func(ctx context.Context, c *Conn, f Frame)
+
+// Gateway is safe for concurrent use
+type Gateway struct {
+	upgrader websocket.Upgrader
+	mu       sync.Mutex
+	conns    map[int64]map[*Conn]struct{}
+	closed   bool
+}
+
+// Conn is one websocket of a user
+type Conn struct {
+	ws      *websocket.Conn
+	gateway *Gateway
+	userID  int64
+	send    chan Frame
+	closed  bool // guarded by gateway.mu
+}
+
+func NewGateway() *Gateway {
+	return &Gateway{
+		upgrader: websocket.Upgrader{
+			ReadBufferSize:  1024,
+			WriteBufferSize: 1024,
+			// we authenticate with tokens and not cookies, so other origins can't
+			// ride on the session of a user and there is nothing to check here
+			CheckOrigin: func(r *http.Request) bool { return true },
+		},
+		conns: map[int64]map[*Conn]struct{}{},
+	}
+}
+
+// Serve upgrades the request of userID to a websocket and handles its frames
+// until the connection closes. when the upgrade fails the error response is already written.
+func (g *Gateway) Serve(w http.ResponseWriter, r *http.Request, userID int64, handle Handler) error {
+	ws, err := g.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return err
+	}
+
+	c := &Conn{ws: ws, gateway: g, userID: userID, send: make(chan Frame, sendBuffer)}
+	if !g.register(c) {
+		ws.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, ""), time.Now().Add(writeWait))
+		return ws.Close()
+	}
+
+	go c.writePump()
+	c.readPump(r.Context(), handle)
+
+	return nil
+}
+
+func (g *Gateway) register(c *Conn) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.closed {
+		return false
+	}
+
+	if g.conns[c.userID] == nil {
+		g.conns[c.userID] = map[*Conn]struct{}{}
+	}
+	g.conns[c.userID][c] = struct{}{}
+
+	return true
+}
+
+// Send sends f to every connection of userID except skip, which can be nil
+func (g *Gateway) Send(userID int64, f Frame, skip *Conn) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for c := range g.conns[userID] {
+		if c != skip {
+			c.sendLocked(f)
+		}
+	}
+}
+
+// Close closes every connection, used on shutdown
+func (g *Gateway) Close() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.closed = true
+	for _, conns := range g.conns {
+		for c := range conns {
+			c.closeLocked()
+		}
+	}
+}
+
+func (c *Conn) UserID() int64 {
+	return c.userID
+}
+
+// Send sends f to this connection only
+func (c *Conn) Send(f Frame) {
+	c.gateway.mu.Lock()
+	defer c.gateway.mu.Unlock()
+
+	c.sendLocked(f)
+}
+
+func (c *Conn) sendLocked(f Frame) {
+	if c.closed {
+		return
+	}
+
+	select {
+	case c.send <- f:
+	default:
+		// a client this far behind is dropped, it loads what it missed over the api
+		c.closeLocked()
+	}
+}
+
+// closeLocked unregisters the connection and makes writePump say goodbye
+func (c *Conn) closeLocked() {
+	if c.closed {
+		return
+	}
+	c.closed = true
+
+	conns := c.gateway.conns[c.userID]
+	delete(conns, c)
+	if len(conns) == 0 {
+		delete(c.gateway.conns, c.userID)
+	}
+
+	close(c.send)
+}
+
+func (c *Conn) close() {
+	c.gateway.mu.Lock()
+	defer c.gateway.mu.Unlock()
+
+	c.closeLocked()
+}
+
+// readPump reads the frames of the client and hands them to handle, it owns all the reads
+func (c *Conn) readPump(ctx context.Context, handle Handler) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer func() {
+		cancel()
+		c.close()
+		c.ws.Close()
+	}()
+
+	c.ws.SetReadLimit(maxFrameSize)
+	c.ws.SetReadDeadline(time.Now().Add(pongWait))
+	c.ws.SetPongHandler(func(string) error {
+		return c.ws.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	for {
+		_, data, err := c.ws.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		var f Frame
+		if err := json.Unmarshal(data, &f); err != nil {
+			c.Send(Frame{Type: FrameError, Error: "frames must be json"})
+			continue
+		}
+
+		handle(ctx, c, f)
+	}
+}
+
+// writePump writes the queued frames and the pings, it owns all the writes
+func (c *Conn) writePump() {
+	ticker := time.NewTicker(pingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.ws.Close()
+	}()
+
+	for {
+		select {
+		case f, ok := <-c.send:
+			c.ws.SetWriteDeadline(time.Now().Add(writeWait))
+			if !ok {
+				c.ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, ""))
+				return
+			}
+			if err := c.ws.WriteJSON(f); err != nil {
+				return
+			}
+		case <-ticker.C:
+			c.ws.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.ws.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
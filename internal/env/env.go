@@ -3,6 +3,7 @@ package env
 import (
 	"os"
 	"strconv"
+	"time"
 )
 
 /*
@@ -37,3 +38,22 @@ func GetInt(key string, fallback int) int {
 
 	return valAsInt
 }
+
+/*
+* GetDuration retrieves a time.Duration (like "15m" or "1h") from an environment variable.
+* If the environment variable is not set or cannot be parsed as a duration,
+* it returns the provided fallback value.
+ */
+func GetDuration(key string, fallback time.Duration) time.Duration {
+	val, ok := os.LookupEnv(key)
+	if !ok {
+		return fallback
+	}
+
+	valAsDuration, err := time.ParseDuration(val)
+	if err != nil {
+		return fallback
+	}
+
+	return valAsDuration
+}
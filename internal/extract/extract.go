@@ -0,0 +1,70 @@
+// Package extract pulls #hashtags and @mentions out of post and comment content.
+package extract
+
+import (
+	"regexp"
+	"strings"
+)
+
+// a tag or mention has to start the text or follow something that isn't part of a word,
+// so emails (a@b.com) and urls (/page#section) don't count
+var (
+	hashtagPattern = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_&/#])#([\p{L}\p{N}_]{1,50})`)
+	mentionPattern = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_.@/])@([\p{L}\p{N}_.\-]{1,100})`)
+)
+
+// Hashtags returns the lower cased hashtags of text without duplicates, in order of appearance
+func Hashtags(text string) []string {
+	var tags []string
+	for _, m := range hashtagPattern.FindAllStringSubmatch(text, -1) {
+		tag := NormalizeTag(m[1])
+		// "#2024" is more likely a number than a topic
+		if strings.Trim(tag, "0123456789") == "" {
+			continue
+		}
+		tags = appendUnique(tags, tag)
+	}
+	return tags
+}
+
+// Mentions returns the lower cased mentioned usernames of text without duplicates, in order of appearance.
+// usernames are unique regardless of case, so "@Bob" and "@bob" are the same user.
+func Mentions(text string) []string {
+	var usernames []string
+	for _, m := range mentionPattern.FindAllStringSubmatch(text, -1) {
+		// "thanks @bob." ends a sentence, it doesn't mention "bob."
+		username := strings.ToLower(strings.TrimRight(m[1], ".-"))
+		if username == "" {
+			continue
+		}
+		usernames = appendUnique(usernames, username)
+	}
+	return usernames
+}
+
+// NormalizeTag is how tags are stored and looked up, "#Go" and "go" are the same tag
+func NormalizeTag(tag string) string {
+	return strings.ToLower(strings.TrimPrefix(strings.TrimSpace(tag), "#"))
+}
+
+// MergeTags normalizes and joins the tag lists, dropping empty tags and duplicates
+func MergeTags(lists ...[]string) []string {
+	tags := []string{}
+	for _, list := range lists {
+		for _, tag := range list {
+			if tag = NormalizeTag(tag); tag != "" {
+				tags = appendUnique(tags, tag)
+			}
+		}
+	}
+	return tags
+}
+
+func appendUnique(list []string, item string) []string {
+	for _, existing := range list {
+		if existing == item {
+			return list
+		}
+	}
+	return append(list, item)
+}
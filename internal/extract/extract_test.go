@@ -0,0 +1,92 @@
+package extract
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestHashtags(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []string
+	}{
+		{"none", "just text", nil},
+		{"start of text", "#go is fun", []string{"go"}},
+		{"lower cased and unique", "#Go and #GO and #go", []string{"go"}},
+		{"in order", "#b then #a", []string{"b", "a"}},
+		{"after punctuation", "(#go), #rust!", []string{"go", "rust"}},
+		{"unicode", "#café #日本", []string{"café", "日本"}},
+		{"numbers only are skipped", "#2024 #go2", []string{"go2"}},
+		{"not inside words", "c#sharp abc#def", nil},
+		{"not in urls", "https://example.com/page#section", nil},
+		{"not html entities", "&#39;", nil},
+		{"double hash", "##go", nil},
+		{"longer than 50 is cut", "#" + strings.Repeat("a", 60), []string{strings.Repeat("a", 50)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Hashtags(tt.text); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Hashtags(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMentions(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []string
+	}{
+		{"none", "just text", nil},
+		{"start of text", "@bob hi", []string{"bob"}},
+		{"lower cased and unique", "@Bob @BOB @bob", []string{"bob"}},
+		{"end of sentence", "thanks @bob.", []string{"bob"}},
+		{"trailing dots and dashes are dropped", "@x- and @y.-.", []string{"x", "y"}},
+		{"dots and dashes inside", "cc @jane.doe-x", []string{"jane.doe-x"}},
+		{"emails are not mentions", "mail me at bob@example.com", nil},
+		{"not in urls", "https://example.com/@bob", nil},
+		{"double at", "@@bob", nil},
+		{"only punctuation", "@. @-", nil},
+		{"after punctuation", "(@alice), @bob!", []string{"alice", "bob"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Mentions(tt.text); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Mentions(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeTag(t *testing.T) {
+	tests := map[string]string{
+		"go":     "go",
+		"#Go":    "go",
+		"  #GO ": "go",
+		"":       "",
+	}
+
+	for in, want := range tests {
+		if got := NormalizeTag(in); got != want {
+			t.Errorf("NormalizeTag(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestMergeTags(t *testing.T) {
+	got := MergeTags([]string{"Go", " ", "#rust"}, []string{"go", "sql"}, nil)
+	want := []string{"go", "rust", "sql"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("MergeTags = %q, want %q", got, want)
+	}
+
+	// never nil, it ends up in a NOT NULL column
+	if got := MergeTags(); got == nil || len(got) != 0 {
+		t.Errorf("MergeTags() = %#v, want an empty slice", got)
+	}
+}
@@ -0,0 +1,86 @@
+package mailer
+
+import (
+	"bytes"
+	"embed"
+	"html/template"
+	"time"
+
+	texttemplate "text/template"
+)
+
+const (
+	FromName               = "GopherSocial"
+	UserInvitationTemplate = "user_invitation.tmpl"
+	PasswordResetTemplate  = "password_reset.tmpl"
+
+	maxRetries     = 3
+	retryBaseDelay = time.Millisecond * 500
+)
+
+//go:embed templates
+var FS embed.FS
+
+// Client sends a templated email to a single user.
+// every template must define "subject", "plainBody" and "htmlBody".
+type Client interface {
+	Send(templateFile, username, email string, data any) error
+}
+
+// message is a rendered email, ready to be delivered
+type message struct {
+	subject   string
+	plainBody string
+	htmlBody  string
+}
+
+func render(templateFile string, data any) (*message, error) {
+	// text/template for subject and plain body so nothing gets html escaped
+	textTmpl, err := texttemplate.ParseFS(FS, "templates/"+templateFile)
+	if err != nil {
+		return nil, err
+	}
+
+	htmlTmpl, err := template.ParseFS(FS, "templates/"+templateFile)
+	if err != nil {
+		return nil, err
+	}
+
+	subject := new(bytes.Buffer)
+	if err := textTmpl.ExecuteTemplate(subject, "subject", data); err != nil {
+		return nil, err
+	}
+
+	plainBody := new(bytes.Buffer)
+	if err := textTmpl.ExecuteTemplate(plainBody, "plainBody", data); err != nil {
+		return nil, err
+	}
+
+	htmlBody := new(bytes.Buffer)
+	if err := htmlTmpl.ExecuteTemplate(htmlBody, "htmlBody", data); err != nil {
+		return nil, err
+	}
+
+	return &message{
+		subject:   subject.String(),
+		plainBody: plainBody.String(),
+		htmlBody:  htmlBody.String(),
+	}, nil
+}
+
+// withRetry calls fn until it works or we run out of retries,
+// waiting a bit longer after every failed attempt (500ms, 1s, 2s, ...)
+func withRetry(fn func() error) error {
+	var err error
+	for i := 0; i < maxRetries; i++ {
+		if err = fn(); err == nil {
+			return nil
+		}
+
+		if i < maxRetries-1 {
+			time.Sleep(retryBaseDelay * time.Duration(1<<i))
+		}
+	}
+
+	return err
+}
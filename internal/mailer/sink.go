@@ -0,0 +1,38 @@
+package mailer
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// SinkMailer does not deliver anything, it writes the rendered email to w.
+// use it for development and tests (w can be os.Stdout or a file).
+type SinkMailer struct {
+	mu        sync.Mutex
+	w         io.Writer
+	fromEmail string
+}
+
+func NewSinkMailer(w io.Writer, fromEmail string) *SinkMailer {
+	return &SinkMailer{w: w, fromEmail: fromEmail}
+}
+
+func (m *SinkMailer) Send(templateFile, username, email string, data any) error {
+	msg, err := render(templateFile, data)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return withRetry(func() error {
+		_, err := fmt.Fprintf(m.w,
+			"----- %s -----\nFrom: %s <%s>\nTo: %s <%s>\nSubject: %s\n%s\n",
+			time.Now().Format(time.RFC3339), FromName, m.fromEmail, username, email, msg.subject, msg.plainBody,
+		)
+		return err
+	})
+}
@@ -0,0 +1,83 @@
+package mailer
+
+import (
+	"bytes"
+	"fmt"
+	"mime/multipart"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+)
+
+type SMTPMailer struct {
+	addr      string
+	auth      smtp.Auth
+	fromEmail string
+}
+
+func NewSMTPMailer(host string, port int, username, password, fromEmail string) *SMTPMailer {
+	var auth smtp.Auth
+	// some local servers (mailhog, mailpit) don't need auth at all
+	if username != "" {
+		auth = smtp.PlainAuth("", username, password, host)
+	}
+
+	return &SMTPMailer{
+		addr:      host + ":" + strconv.Itoa(port),
+		auth:      auth,
+		fromEmail: fromEmail,
+	}
+}
+
+func (m *SMTPMailer) Send(templateFile, username, email string, data any) error {
+	msg, err := render(templateFile, data)
+	if err != nil {
+		return err
+	}
+
+	raw, err := m.build(email, msg)
+	if err != nil {
+		return err
+	}
+
+	return withRetry(func() error {
+		return smtp.SendMail(m.addr, m.auth, m.fromEmail, []string{email}, raw)
+	})
+}
+
+// build creates a multipart/alternative email so clients can pick text or html
+func (m *SMTPMailer) build(to string, msg *message) ([]byte, error) {
+	body := new(bytes.Buffer)
+	writer := multipart.NewWriter(body)
+
+	parts := []struct {
+		contentType string
+		content     string
+	}{
+		{"text/plain; charset=UTF-8", msg.plainBody},
+		{"text/html; charset=UTF-8", msg.htmlBody},
+	}
+
+	for _, p := range parts {
+		w, err := writer.CreatePart(textproto.MIMEHeader{"Content-Type": {p.contentType}})
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write([]byte(p.content)); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	header := new(bytes.Buffer)
+	fmt.Fprintf(header, "From: %s <%s>\r\n", FromName, m.fromEmail)
+	fmt.Fprintf(header, "To: %s\r\n", to)
+	fmt.Fprintf(header, "Subject: %s\r\n", msg.subject)
+	fmt.Fprintf(header, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(header, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", writer.Boundary())
+
+	return append(header.Bytes(), body.Bytes()...), nil
+}
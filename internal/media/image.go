@@ -0,0 +1,135 @@
+// Package media checks the images users upload and makes their thumbnails, only with the standard library
+package media
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+
+	// register the decoders image.Decode needs, jpeg comes with the import above
+	_ "image/gif"
+	_ "image/png"
+
+	"github.com/gabriel-vasile/mimetype"
+)
+
+const (
+	MaxUploadSize = 5 << 20 // 5mb per image
+	// decoding allocates about 4 bytes per pixel, a small file can still claim to be huge
+	MaxPixels = 40_000_000
+)
+
+var (
+	ErrUnsupportedType = errors.New("only jpeg, png and gif images are allowed")
+	ErrImageTooLarge   = fmt.Errorf("images can't have more than %d pixels", MaxPixels)
+)
+
+// the types we accept and the extension we store them with
+var allowedTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+}
+
+type Image struct {
+	ContentType string
+	Ext         string
+	Width       int
+	Height      int
+}
+
+// Inspect sniffs the type of data from its content, never trust the name or the
+// Content-Type the client sent, and reads the size without decoding the whole image
+func Inspect(data []byte) (*Image, error) {
+	contentType := mimetype.Detect(data).String()
+	ext, ok := allowedTypes[contentType]
+	if !ok {
+		return nil, ErrUnsupportedType
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("broken image: %w", err)
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 {
+		return nil, fmt.Errorf("broken image: it is %dx%d", cfg.Width, cfg.Height)
+	}
+	if cfg.Width*cfg.Height > MaxPixels {
+		return nil, ErrImageTooLarge
+	}
+
+	return &Image{ContentType: contentType, Ext: ext, Width: cfg.Width, Height: cfg.Height}, nil
+}
+
+// Thumbnail returns a jpeg of data that fits in a maxSide x maxSide box.
+// gifs lose their animation and transparent pixels turn white.
+func Thumbnail(data []byte, maxSide int) ([]byte, error) {
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w > maxSide || h > maxSide {
+		if w >= h {
+			w, h = maxSide, max(1, h*maxSide/w)
+		} else {
+			w, h = max(1, w*maxSide/h), maxSide
+		}
+	}
+
+	// jpeg has no transparency, so draw on white first
+	flat := image.NewRGBA(b)
+	draw.Draw(flat, b, image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(flat, b, src, b.Min, draw.Over)
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, shrink(flat, w, h), &jpeg.Options{Quality: 80}); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// shrink scales src down to w x h, every pixel of the result is the average of the
+// source pixels it covers (a box filter), good enough for thumbnails
+func shrink(src *image.RGBA, w, h int) *image.RGBA {
+	b := src.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+	if sw == w && sh == h {
+		return src
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		y0, y1 := y*sh/h, max((y+1)*sh/h, y*sh/h+1)
+		for x := 0; x < w; x++ {
+			x0, x1 := x*sw/w, max((x+1)*sw/w, x*sw/w+1)
+
+			var r, g, bl, n uint64
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[sy*src.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					p := row[sx*4 : sx*4+4]
+					r += uint64(p[0])
+					g += uint64(p[1])
+					bl += uint64(p[2])
+					n++
+				}
+			}
+
+			i := y*dst.Stride + x*4
+			dst.Pix[i] = uint8(r / n)
+			dst.Pix[i+1] = uint8(g / n)
+			dst.Pix[i+2] = uint8(bl / n)
+			dst.Pix[i+3] = 0xff
+		}
+	}
+
+	return dst
+}
@@ -0,0 +1,177 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func encode(t *testing.T, format string, img image.Image) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	var err error
+	switch format {
+	case "png":
+		err = png.Encode(&buf, img)
+	case "jpeg":
+		err = jpeg.Encode(&buf, img, nil)
+	case "gif":
+		err = gif.Encode(&buf, img, nil)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func solid(w, h int, c color.Color) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+// pngClaiming is a valid 1x1 png with the size in its header changed to w x h,
+// decoding the header is enough to see the size
+func pngClaiming(t *testing.T, w, h uint32) []byte {
+	data := encode(t, "png", solid(1, 1, color.Black))
+
+	// 8 bytes of signature, then the IHDR chunk: length, "IHDR", width, height, ...
+	ihdr := data[8+8 : 8+8+13]
+	binary.BigEndian.PutUint32(ihdr[0:4], w)
+	binary.BigEndian.PutUint32(ihdr[4:8], h)
+	crc := crc32.ChecksumIEEE(data[8+4 : 8+8+13])
+	binary.BigEndian.PutUint32(data[8+8+13:], crc)
+
+	return data
+}
+
+func TestInspect(t *testing.T) {
+	tests := []struct {
+		name        string
+		data        []byte
+		contentType string
+		ext         string
+		err         error
+	}{
+		{"png", encode(t, "png", solid(30, 20, color.White)), "image/png", ".png", nil},
+		{"jpeg", encode(t, "jpeg", solid(30, 20, color.White)), "image/jpeg", ".jpg", nil},
+		{"gif", encode(t, "gif", solid(30, 20, color.White)), "image/gif", ".gif", nil},
+		{"text", []byte("<html><script>alert(1)</script></html>"), "", "", ErrUnsupportedType},
+		{"svg", []byte(`<svg xmlns="http://www.w3.org/2000/svg"></svg>`), "", "", ErrUnsupportedType},
+		{"empty", nil, "", "", ErrUnsupportedType},
+		{"bomb", pngClaiming(t, 100_000, 100_000), "", "", ErrImageTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img, err := Inspect(tt.data)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("err = %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if img.ContentType != tt.contentType || img.Ext != tt.ext || img.Width != 30 || img.Height != 20 {
+				t.Errorf("got %+v, want %s %s 30x20", img, tt.contentType, tt.ext)
+			}
+		})
+	}
+}
+
+func TestInspectSniffsTheContent(t *testing.T) {
+	// html behind a gif magic number is sniffed as a gif but doesn't decode as one
+	fake := append([]byte("GIF89a"), []byte("<script>alert(1)</script>")...)
+	if _, err := Inspect(fake); err == nil {
+		t.Error("expected an error for html with a gif header")
+	}
+
+	data := encode(t, "png", solid(2, 2, color.White))
+
+	// the right magic bytes with garbage after them are not an image
+	broken := append([]byte{}, data[:20]...)
+	if _, err := Inspect(broken); err == nil {
+		t.Error("expected an error for a truncated png")
+	}
+}
+
+func TestThumbnail(t *testing.T) {
+	tests := []struct {
+		name    string
+		w, h    int
+		maxSide int
+		wantW   int
+		wantH   int
+	}{
+		{"landscape", 640, 320, 320, 320, 160},
+		{"portrait", 100, 400, 320, 80, 320},
+		{"square", 500, 500, 320, 320, 320},
+		{"small stays", 50, 30, 320, 50, 30},
+		{"thin line keeps a pixel", 1000, 1, 320, 320, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := encode(t, "png", solid(tt.w, tt.h, color.RGBA{200, 0, 0, 255}))
+
+			thumb, err := Thumbnail(data, tt.maxSide)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			img, format, err := image.Decode(bytes.NewReader(thumb))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if format != "jpeg" {
+				t.Errorf("format = %q, want jpeg", format)
+			}
+			if b := img.Bounds(); b.Dx() != tt.wantW || b.Dy() != tt.wantH {
+				t.Errorf("size = %dx%d, want %dx%d", b.Dx(), b.Dy(), tt.wantW, tt.wantH)
+			}
+		})
+	}
+}
+
+func TestThumbnailFlattensTransparency(t *testing.T) {
+	data := encode(t, "png", solid(10, 10, color.Transparent))
+
+	thumb, err := Thumbnail(data, 320)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, _, err := image.Decode(bytes.NewReader(thumb))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, g, b, _ := img.At(5, 5).RGBA()
+	if r>>8 < 250 || g>>8 < 250 || b>>8 < 250 {
+		t.Errorf("pixel = %d,%d,%d, want white", r>>8, g>>8, b>>8)
+	}
+}
+
+func TestShrinkAverages(t *testing.T) {
+	// half black, half white, shrunk to a single pixel is grey
+	src := solid(2, 1, color.Black)
+	src.Set(1, 0, color.White)
+
+	dst := shrink(src, 1, 1)
+	if got := dst.RGBAAt(0, 0); got.R != 127 || got.A != 255 {
+		t.Errorf("pixel = %+v, want 127 grey", got)
+	}
+}
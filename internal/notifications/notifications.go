@@ -0,0 +1,59 @@
+// Package notifications stores the in-app notifications of users.
+//
+// producers live in the store package and call Insert inside their own
+// transactions, so a notification exists exactly when the thing it is about does.
+// this package must not import store.
+package notifications
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// notification kinds
+const (
+	KindFollow  = "follow"  // actor followed you
+	KindComment = "comment" // actor commented on your post
+	KindReply   = "reply"   // actor replied to your comment
+	KindMention = "mention" // actor mentioned you in a post or comment
+)
+
+const queryTimeout = time.Second * 5
+
+type Notification struct {
+	ID        int64      `json:"id"`
+	UserID    int64      `json:"user_id"`
+	ActorID   int64      `json:"actor_id"`
+	ActorName string     `json:"actor_username"`
+	Kind      string     `json:"kind"`
+	PostID    *int64     `json:"post_id,omitempty"`
+	CommentID *int64     `json:"comment_id,omitempty"`
+	ReadAt    *time.Time `json:"read_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// Insert adds n inside tx. nothing is stored when the actor is the user
+// or when one of them blocked the other.
+func Insert(ctx context.Context, tx *sql.Tx, n *Notification) error {
+	if n.UserID == n.ActorID {
+		return nil
+	}
+
+	query := `
+		INSERT INTO notifications (user_id, actor_id, kind, post_id, comment_id)
+		SELECT $1, $2, $3, $4, $5
+		WHERE NOT EXISTS (
+			SELECT 1 FROM blocks
+			WHERE (blocker_id = $1 AND blocked_id = $2) OR (blocker_id = $2 AND blocked_id = $1)
+		)
+		RETURNING id, created_at
+	`
+
+	err := tx.QueryRowContext(ctx, query, n.UserID, n.ActorID, n.Kind, n.PostID, n.CommentID).Scan(&n.ID, &n.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+
+	return err
+}
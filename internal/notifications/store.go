@@ -0,0 +1,173 @@
+package notifications
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"strconv"
+
+	"github.com/lib/pq"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Store is the read side of notifications
+type Store struct {
+	db *sql.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+type Page struct {
+	Notifications []Notification
+	NextCursor    string
+}
+
+// ids only grow so the id alone is a stable cursor
+func encodeCursor(id int64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(id, 10)))
+}
+
+func decodeCursor(cursor string) (int64, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, ErrInvalidCursor
+	}
+
+	id, err := strconv.ParseInt(string(data), 10, 64)
+	if err != nil || id <= 0 {
+		return 0, ErrInvalidCursor
+	}
+
+	return id, nil
+}
+
+// List pages through the notifications of userID, newest first
+func (s *Store) List(ctx context.Context, userID int64, limit int, cursor string, unreadOnly bool) (*Page, error) {
+	var before int64
+	if cursor != "" {
+		var err error
+		if before, err = decodeCursor(cursor); err != nil {
+			return nil, err
+		}
+	}
+
+	query := `
+		SELECT n.id, n.user_id, n.actor_id, u.username, n.kind, n.post_id, n.comment_id, n.read_at, n.created_at
+		FROM notifications AS n
+		JOIN users AS u ON u.id = n.actor_id
+		WHERE
+			n.user_id = $1 AND
+			($2::bigint = 0 OR n.id < $2) AND
+			(NOT $3 OR n.read_at IS NULL)
+		ORDER BY n.id DESC
+		LIMIT $4
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, userID, before, unreadOnly, limit+1)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []Notification{}
+	for rows.Next() {
+		var n Notification
+		err := rows.Scan(
+			&n.ID,
+			&n.UserID,
+			&n.ActorID,
+			&n.ActorName,
+			&n.Kind,
+			&n.PostID,
+			&n.CommentID,
+			&n.ReadAt,
+			&n.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, n)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	page := &Page{Notifications: list}
+	if len(list) > limit {
+		page.Notifications = list[:limit]
+		page.NextCursor = encodeCursor(page.Notifications[limit-1].ID)
+	}
+
+	return page, nil
+}
+
+func (s *Store) Get(ctx context.Context, id int64) (*Notification, error) {
+	query := `
+		SELECT n.id, n.user_id, n.actor_id, u.username, n.kind, n.post_id, n.comment_id, n.read_at, n.created_at
+		FROM notifications AS n
+		JOIN users AS u ON u.id = n.actor_id
+		WHERE n.id = $1
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+
+	n := &Notification{}
+	err := s.db.QueryRowContext(ctx, query, id).Scan(
+		&n.ID,
+		&n.UserID,
+		&n.ActorID,
+		&n.ActorName,
+		&n.Kind,
+		&n.PostID,
+		&n.CommentID,
+		&n.ReadAt,
+		&n.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return n, nil
+}
+
+func (s *Store) UnreadCount(ctx context.Context, userID int64) (int, error) {
+	query := `SELECT COUNT(*) FROM notifications WHERE user_id = $1 AND read_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+
+	var count int
+	err := s.db.QueryRowContext(ctx, query, userID).Scan(&count)
+	return count, err
+}
+
+// MarkRead marks the given notifications of userID as read, all of them when ids is empty.
+// it returns how many were unread before.
+func (s *Store) MarkRead(ctx context.Context, userID int64, ids []int64) (int64, error) {
+	query := `
+		UPDATE notifications SET read_at = NOW()
+		WHERE user_id = $1 AND read_at IS NULL AND (cardinality($2::bigint[]) = 0 OR id = ANY($2))
+	`
+
+	if ids == nil {
+		ids = []int64{}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+
+	result, err := s.db.ExecContext(ctx, query, userID, pq.Array(ids))
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
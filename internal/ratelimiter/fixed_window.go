@@ -0,0 +1,74 @@
+package ratelimiter
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// FixedWindowLimiter counts requests in fixed windows (12:00:00-12:01:00, ...),
+// it is cheap but allows up to 2x the limit around the edge of a window
+type FixedWindowLimiter struct {
+	mu      sync.Mutex
+	cfg     Config
+	windows map[string]*window
+	now     func() time.Time
+}
+
+type window struct {
+	start time.Time
+	count int
+}
+
+func NewFixedWindowLimiter(cfg Config) *FixedWindowLimiter {
+	return &FixedWindowLimiter{
+		cfg:     cfg,
+		windows: make(map[string]*window),
+		now:     time.Now,
+	}
+}
+
+func (l *FixedWindowLimiter) Allow(ctx context.Context, key string) (Result, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	start := now.Truncate(l.cfg.Window)
+
+	w, ok := l.windows[key]
+	if !ok || !w.start.Equal(start) {
+		// a new window started, forget the old ones so the map doesn't grow forever
+		if ok {
+			l.sweep(start)
+		}
+		w = &window{start: start}
+		l.windows[key] = w
+	}
+
+	reset := start.Add(l.cfg.Window)
+	if w.count >= l.cfg.Requests {
+		return Result{
+			Allowed:    false,
+			Limit:      l.cfg.Requests,
+			Remaining:  0,
+			RetryAfter: reset.Sub(now),
+			Reset:      reset,
+		}, nil
+	}
+
+	w.count++
+	return Result{
+		Allowed:   true,
+		Limit:     l.cfg.Requests,
+		Remaining: l.cfg.Requests - w.count,
+		Reset:     reset,
+	}, nil
+}
+
+func (l *FixedWindowLimiter) sweep(current time.Time) {
+	for key, w := range l.windows {
+		if w.start.Before(current) {
+			delete(l.windows, key)
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package ratelimiter
+
+import (
+	"context"
+	"time"
+)
+
+// Limiter decides if the caller identified by key can make one more request
+type Limiter interface {
+	Allow(ctx context.Context, key string) (Result, error)
+}
+
+// Result is everything we need to fill the X-RateLimit-* headers
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// RetryAfter is how long the caller should wait, zero when allowed
+	RetryAfter time.Duration
+	// Reset is when the caller gets its full quota back
+	Reset time.Time
+}
+
+// Config means "at most Requests requests every Window"
+type Config struct {
+	Requests int
+	Window   time.Duration
+}
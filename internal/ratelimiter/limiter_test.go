@@ -0,0 +1,152 @@
+package ratelimiter
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// fakeClock is a clock the test moves by hand
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time          { return c.t }
+func (c *fakeClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func allow(t *testing.T, l Limiter, key string) Result {
+	t.Helper()
+
+	res, err := l.Allow(context.Background(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func TestTokenBucketLimiter(t *testing.T) {
+	clock := &fakeClock{t: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	l := NewTokenBucketLimiter(Config{Requests: 3, Window: time.Minute})
+	l.now = clock.now
+
+	// a full bucket allows a burst of 3
+	for i, remaining := range []int{2, 1, 0} {
+		res := allow(t, l, "a")
+		if !res.Allowed || res.Remaining != remaining || res.Limit != 3 {
+			t.Fatalf("request %d = %+v, want allowed with %d remaining", i+1, res, remaining)
+		}
+	}
+
+	res := allow(t, l, "a")
+	if res.Allowed {
+		t.Fatalf("4th request = %+v, want denied", res)
+	}
+	// one token every 20s
+	if res.RetryAfter != time.Second*20 {
+		t.Errorf("retry after = %v, want 20s", res.RetryAfter)
+	}
+	if want := clock.t.Add(time.Minute); !res.Reset.Equal(want) {
+		t.Errorf("reset = %v, want %v", res.Reset, want)
+	}
+
+	// other keys have their own bucket
+	if res := allow(t, l, "b"); !res.Allowed {
+		t.Errorf("other key = %+v, want allowed", res)
+	}
+
+	// part of a token isn't enough
+	clock.advance(time.Second * 10)
+	if res := allow(t, l, "a"); res.Allowed || res.RetryAfter != time.Second*10 {
+		t.Errorf("after 10s = %+v, want denied for 10 more seconds", res)
+	}
+
+	clock.advance(time.Second * 10)
+	if res := allow(t, l, "a"); !res.Allowed || res.Remaining != 0 {
+		t.Errorf("after 20s = %+v, want allowed with 0 remaining", res)
+	}
+
+	// the bucket never holds more than its capacity
+	clock.advance(time.Hour)
+	for i := 0; i < 3; i++ {
+		allow(t, l, "a")
+	}
+	if res := allow(t, l, "a"); res.Allowed {
+		t.Errorf("after an hour = %+v, want only 3 requests allowed", res)
+	}
+}
+
+func TestTokenBucketLimiterSweep(t *testing.T) {
+	clock := &fakeClock{t: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	l := NewTokenBucketLimiter(Config{Requests: 1, Window: time.Minute})
+	l.now = clock.now
+	l.lastSweep = clock.t
+
+	allow(t, l, "a")
+	allow(t, l, "b")
+
+	clock.advance(time.Minute)
+	allow(t, l, "c")
+
+	if len(l.buckets) != 1 {
+		t.Errorf("buckets = %d, want the full ones dropped", len(l.buckets))
+	}
+}
+
+func TestFixedWindowLimiter(t *testing.T) {
+	clock := &fakeClock{t: time.Date(2024, 1, 1, 12, 0, 30, 0, time.UTC)}
+	l := NewFixedWindowLimiter(Config{Requests: 2, Window: time.Minute})
+	l.now = clock.now
+
+	windowEnd := time.Date(2024, 1, 1, 12, 1, 0, 0, time.UTC)
+
+	for i, remaining := range []int{1, 0} {
+		res := allow(t, l, "a")
+		if !res.Allowed || res.Remaining != remaining || !res.Reset.Equal(windowEnd) {
+			t.Fatalf("request %d = %+v, want allowed with %d remaining until %v", i+1, res, remaining, windowEnd)
+		}
+	}
+
+	res := allow(t, l, "a")
+	if res.Allowed || res.RetryAfter != time.Second*30 {
+		t.Fatalf("3rd request = %+v, want denied for 30s", res)
+	}
+
+	if res := allow(t, l, "b"); !res.Allowed {
+		t.Errorf("other key = %+v, want allowed", res)
+	}
+
+	// a new window starts with a new count, whatever happened at the end of the last one
+	clock.advance(time.Second * 30)
+	if res := allow(t, l, "a"); !res.Allowed || res.Remaining != 1 {
+		t.Errorf("next window = %+v, want allowed with 1 remaining", res)
+	}
+
+	// the old window of "b" was swept when "a" moved on
+	if _, ok := l.windows["b"]; ok {
+		t.Error("the window of b from the last minute is still there")
+	}
+}
+
+func TestFixedWindowLimiterEdge(t *testing.T) {
+	// the known weakness: up to 2x the limit around the edge of a window
+	clock := &fakeClock{t: time.Date(2024, 1, 1, 12, 0, 59, 0, time.UTC)}
+	l := NewFixedWindowLimiter(Config{Requests: 2, Window: time.Minute})
+	l.now = clock.now
+
+	allowed := 0
+	for i := 0; i < 3; i++ {
+		if allow(t, l, "a").Allowed {
+			allowed++
+		}
+	}
+	clock.advance(time.Second)
+	for i := 0; i < 3; i++ {
+		if allow(t, l, "a").Allowed {
+			allowed++
+		}
+	}
+
+	if allowed != 4 {
+		t.Errorf("allowed %d requests in 2 seconds, want 4", allowed)
+	}
+}
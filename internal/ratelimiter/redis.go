@@ -0,0 +1,54 @@
+package ratelimiter
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisFixedWindowLimiter is a FixedWindowLimiter shared by every instance of the api
+type RedisFixedWindowLimiter struct {
+	rdb    redis.Cmdable
+	cfg    Config
+	prefix string
+}
+
+func NewRedisFixedWindowLimiter(rdb redis.Cmdable, cfg Config, prefix string) *RedisFixedWindowLimiter {
+	return &RedisFixedWindowLimiter{rdb: rdb, cfg: cfg, prefix: prefix}
+}
+
+func (l *RedisFixedWindowLimiter) Allow(ctx context.Context, key string) (Result, error) {
+	now := time.Now()
+	start := now.Truncate(l.cfg.Window)
+	reset := start.Add(l.cfg.Window)
+
+	// one key per window, redis removes it for us when the window is over
+	redisKey := fmt.Sprintf("%s:%s:%d", l.prefix, key, start.Unix())
+
+	pipe := l.rdb.TxPipeline()
+	incr := pipe.Incr(ctx, redisKey)
+	pipe.ExpireNX(ctx, redisKey, l.cfg.Window)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return Result{}, err
+	}
+
+	count := int(incr.Val())
+	if count > l.cfg.Requests {
+		return Result{
+			Allowed:    false,
+			Limit:      l.cfg.Requests,
+			Remaining:  0,
+			RetryAfter: reset.Sub(now),
+			Reset:      reset,
+		}, nil
+	}
+
+	return Result{
+		Allowed:   true,
+		Limit:     l.cfg.Requests,
+		Remaining: l.cfg.Requests - count,
+		Reset:     reset,
+	}, nil
+}
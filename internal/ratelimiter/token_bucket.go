@@ -0,0 +1,90 @@
+package ratelimiter
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// TokenBucketLimiter gives every key a bucket of cfg.Requests tokens that refills
+// evenly over cfg.Window, so short bursts are fine but the average rate is capped
+type TokenBucketLimiter struct {
+	mu        sync.Mutex
+	cfg       Config
+	rate      float64 // tokens per second
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func NewTokenBucketLimiter(cfg Config) *TokenBucketLimiter {
+	return &TokenBucketLimiter{
+		cfg:       cfg,
+		rate:      float64(cfg.Requests) / cfg.Window.Seconds(),
+		buckets:   make(map[string]*bucket),
+		lastSweep: time.Now(),
+		now:       time.Now,
+	}
+}
+
+func (l *TokenBucketLimiter) Allow(ctx context.Context, key string) (Result, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	capacity := float64(l.cfg.Requests)
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: capacity, last: now}
+		l.buckets[key] = b
+	}
+
+	// refill for the time that passed since the last request
+	b.tokens = math.Min(capacity, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		return Result{
+			Allowed:    false,
+			Limit:      l.cfg.Requests,
+			Remaining:  0,
+			RetryAfter: l.duration(1 - b.tokens),
+			Reset:      now.Add(l.duration(capacity - b.tokens)),
+		}, nil
+	}
+
+	b.tokens--
+	return Result{
+		Allowed:   true,
+		Limit:     l.cfg.Requests,
+		Remaining: int(b.tokens),
+		Reset:     now.Add(l.duration(capacity - b.tokens)),
+	}, nil
+}
+
+// duration is how long it takes to refill n tokens
+func (l *TokenBucketLimiter) duration(n float64) time.Duration {
+	return time.Duration(n / l.rate * float64(time.Second))
+}
+
+// sweep drops buckets that are full again, once per window is enough
+func (l *TokenBucketLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < l.cfg.Window {
+		return
+	}
+	l.lastSweep = now
+
+	for key, b := range l.buckets {
+		if now.Sub(b.last) >= l.cfg.Window {
+			delete(l.buckets, key)
+		}
+	}
+}
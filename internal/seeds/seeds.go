@@ -10,6 +10,17 @@ import (
 	"github.com/sirUnchained/udemy-backend-course/internal/store"
 )
 
+// Config controls how much data we generate, the same Seed value
+// always generates the same data set
+type Config struct {
+	Users          int
+	Posts          int
+	Comments       int
+	FollowsPerUser int
+	Seed           int64
+	BatchSize      int
+}
+
 var usernames = []string{
 	"bluecat42", "greendog88", "redbird17", "fastcar305", "slowhouse99", "bigtree64", "smallbook23", "happysun71",
 	"saddog456", "coolstar12", "hotmoon89", "newriver34", "oldmountain67", "smartfood95", "dumbgame28",
@@ -22,98 +33,172 @@ var usernames = []string{
 }
 var adjectives = []string{"Adventurous", "Creative", "Curious", "Friendly", "Helpful", "Honest", "Kind", "Loyal", "Patient", "Reliable"}
 var hobbies = []string{"loves hiking", "enjoys reading", "plays guitar", "codes for fun", "travels often", "cooks meals", "photographs nature", "paints landscapes", "writes stories", "studies history"}
+var tags = []string{"golang", "backend", "postgres", "docker", "travel", "music", "books", "food", "art", "sports"}
 var qualities = []string{"always learning", "seeking challenges", "making friends", "exploring new places", "helping others", "sharing knowledge", "building things", "solving problems", "creating art", "teaching skills"}
 
-func Seed(st store.Storage, debugMode bool, db *sql.DB) {
-	if !debugMode {
-		log.Println("we are not in debug mode, so ignore seeds")
-		return
+// Seed generates the data set described by cfg and inserts it in a single
+// transaction, so a failed run leaves nothing behind
+func Seed(ctx context.Context, db *sql.DB, st store.Storage, cfg Config) error {
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 500
 	}
-	ctx := context.Background()
+	rng := rand.New(rand.NewSource(cfg.Seed))
 
-	users := generateUsers(100)
-	tx, _ := db.BeginTx(ctx, nil)
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
 
-	for _, user := range users {
-		if err := st.Users.Create(ctx, tx, user); err != nil {
-			tx.Rollback()
-			log.Println("Error: ", err)
-			return
+	users, err := generateUsers(cfg.Users)
+	if err != nil {
+		return err
+	}
+	for _, batch := range batches(users, cfg.BatchSize) {
+		if err := st.Users.CreateBatch(ctx, tx, batch); err != nil {
+			return err
 		}
 	}
+	log.Printf("seeded %d users", len(users))
 
-	tx.Commit()
+	// users have their ids now, so posts and comments point to real rows
+	posts := generatePosts(rng, cfg.Posts, users)
+	for _, batch := range batches(posts, cfg.BatchSize) {
+		if err := st.Posts.CreateBatch(ctx, tx, batch); err != nil {
+			return err
+		}
+	}
+	log.Printf("seeded %d posts", len(posts))
 
-	posts := generatePosts(100, users)
-	for _, post := range posts {
-		if err := st.Posts.Create(ctx, post); err != nil {
-			log.Println("Error: ", err)
-			return
+	comments := generateComments(rng, cfg.Comments, posts, users)
+	for _, batch := range batches(comments, cfg.BatchSize) {
+		if err := st.Comments.CreateBatch(ctx, tx, batch); err != nil {
+			return err
 		}
 	}
+	log.Printf("seeded %d comments", len(comments))
 
-	comments := generateComments(100, posts, users)
-	for _, comment := range comments {
-		if err := st.Comments.Create(ctx, comment); err != nil {
-			log.Println("Error: ", err)
-			return
+	follows := generateFollows(rng, cfg.FollowsPerUser, users)
+	for _, batch := range batches(follows, cfg.BatchSize) {
+		if err := st.Followers.FollowBatch(ctx, tx, batch); err != nil {
+			return err
 		}
 	}
+	log.Printf("seeded %d follows", len(follows))
 
+	return tx.Commit()
 }
 
-func generateUsers(num int) []*store.User {
+// Reset removes every row the seeder creates (roles stay) and restarts the ids,
+// running it twice is the same as running it once
+func Reset(ctx context.Context, db *sql.DB) error {
+	query := `TRUNCATE TABLE users, posts, comments, followers, user_invitations RESTART IDENTITY CASCADE`
+
+	_, err := db.ExecContext(ctx, query)
+	return err
+}
+
+func generateUsers(num int) ([]*store.User, error) {
 	users := make([]*store.User, num)
 
+	// bcrypt is slow on purpose, hash the password once and share it
+	var pass store.User
+	if err := pass.Password.Set("12341234"); err != nil {
+		return nil, err
+	}
+
 	for i := 0; i < num; i++ {
 		users[i] = &store.User{
 			UserName: usernames[i%len(usernames)] + fmt.Sprintf("%d", i),
 			Email:    usernames[i%len(usernames)] + fmt.Sprintf("%d", i) + "@example.com",
+			Password: pass.Password,
+			IsActive: true,
 		}
-		users[i].Password.Set("12341234")
 	}
 
-	return users
+	return users, nil
 }
 
-func generatePosts(num int, users []*store.User) []*store.Post {
+func generatePosts(rng *rand.Rand, num int, users []*store.User) []*store.Post {
+	if len(users) == 0 {
+		return nil
+	}
 	posts := make([]*store.Post, num)
 
 	// Randomly create a content
 	for i := 0; i < num; i++ {
-		content := adjectives[rand.Intn(len(adjectives))] + " person who " +
-			hobbies[rand.Intn(len(hobbies))] + " and " +
-			qualities[rand.Intn(len(qualities))] + "."
-		title := qualities[rand.Intn(len(adjectives))] + " and " + hobbies[rand.Intn(len(hobbies))]
-		user := users[rand.Intn(len(users))]
+		content := adjectives[rng.Intn(len(adjectives))] + " person who " +
+			hobbies[rng.Intn(len(hobbies))] + " and " +
+			qualities[rng.Intn(len(qualities))] + "."
+		title := qualities[rng.Intn(len(qualities))] + " and " + hobbies[rng.Intn(len(hobbies))]
+		user := users[rng.Intn(len(users))]
 		posts[i] = &store.Post{
 			UserID:  user.ID,
 			Title:   title,
 			Content: content,
-			User:    *user,
+			User:    store.Author{ID: user.ID, UserName: user.UserName},
 			Version: 0,
-			Tags:    []string{"t1", "21", "t3"},
+			Tags:    []string{tags[rng.Intn(len(tags))], tags[rng.Intn(len(tags))]},
 		}
 	}
 
 	return posts
 }
 
-func generateComments(num int, posts []*store.Post, users []*store.User) []*store.Comment {
+func generateComments(rng *rand.Rand, num int, posts []*store.Post, users []*store.User) []*store.Comment {
+	if len(posts) == 0 || len(users) == 0 {
+		return nil
+	}
 	comments := make([]*store.Comment, num)
 
 	for i := 0; i < num; i++ {
-		content := adjectives[rand.Intn(len(adjectives))] + " person who " +
-			hobbies[rand.Intn(len(hobbies))] + " and " +
-			qualities[rand.Intn(len(qualities))] + "."
-		user := users[rand.Intn(len(users))]
+		content := adjectives[rng.Intn(len(adjectives))] + " person who " +
+			hobbies[rng.Intn(len(hobbies))] + " and " +
+			qualities[rng.Intn(len(qualities))] + "."
+		user := users[rng.Intn(len(users))]
 		comments[i] = &store.Comment{
 			UserID:  user.ID,
-			PostID:  posts[i].ID,
+			PostID:  posts[rng.Intn(len(posts))].ID,
 			Content: content,
-			User:    *user,
+			User:    store.Author{ID: user.ID, UserName: user.UserName},
 		}
 	}
 
 	return comments
 }
+
+// generateFollows makes every user follow perUser other random users
+func generateFollows(rng *rand.Rand, perUser int, users []*store.User) []store.Follower {
+	if perUser >= len(users) {
+		perUser = len(users) - 1
+	}
+	if perUser <= 0 {
+		return nil
+	}
+
+	follows := make([]store.Follower, 0, perUser*len(users))
+	for _, follower := range users {
+		picked := map[int64]bool{follower.ID: true}
+		for len(picked) <= perUser {
+			followed := users[rng.Intn(len(users))]
+			if picked[followed.ID] {
+				continue
+			}
+			picked[followed.ID] = true
+			follows = append(follows, store.Follower{UserID: followed.ID, FollowerID: follower.ID})
+		}
+	}
+
+	return follows
+}
+
+func batches[T any](items []T, size int) [][]T {
+	var out [][]T
+	for size < len(items) {
+		items, out = items[size:], append(out, items[:size])
+	}
+	if len(items) > 0 {
+		out = append(out, items)
+	}
+	return out
+}
@@ -0,0 +1,232 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const MaxAttachmentsPerPost = 4
+
+var ErrTooManyAttachments = fmt.Errorf("a post can't have more than %d attachments", MaxAttachmentsPerPost)
+
+type AttachmentStore struct {
+	db      *sql.DB
+	blobURL URLFunc
+}
+
+type Attachment struct {
+	ID           int64     `json:"id"`
+	PostID       int64     `json:"post_id"`
+	UserID       int64     `json:"-"`
+	BlobKey      string    `json:"-"`
+	ThumbnailKey string    `json:"-"`
+	URL          string    `json:"url"`           // built from BlobKey
+	ThumbnailURL string    `json:"thumbnail_url"` // built from ThumbnailKey
+	ContentType  string    `json:"content_type"`
+	Size         int64     `json:"size"`
+	Width        int       `json:"width"`
+	Height       int       `json:"height"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// URLFunc turns a blob key into the url clients download the blob from
+type URLFunc func(key string) string
+
+// setURLs fills the urls from the keys, they are never stored so the blob store can move
+func (a *Attachment) setURLs(blobURL URLFunc) {
+	if blobURL == nil {
+		return
+	}
+	a.URL = blobURL(a.BlobKey)
+	a.ThumbnailURL = blobURL(a.ThumbnailKey)
+}
+
+// attachmentColumns reads the attachments of the post p as a json array, scan it with scanAttachments
+const attachmentColumns = `
+	(SELECT COALESCE(json_agg(json_build_object(
+			'id', a.id, 'post_id', a.post_id, 'blob_key', a.blob_key, 'thumbnail_key', a.thumbnail_key,
+			'content_type', a.content_type, 'size', a.size, 'width', a.width, 'height', a.height,
+			'created_at', a.created_at
+		) ORDER BY a.id), '[]')
+		FROM attachments AS a WHERE a.post_id = p.id) AS attachments`
+
+type attachmentList struct {
+	dest    *[]Attachment
+	blobURL URLFunc
+}
+
+// scanAttachments is the scan target for attachmentColumns
+func scanAttachments(dest *[]Attachment, blobURL URLFunc) *attachmentList {
+	return &attachmentList{dest: dest, blobURL: blobURL}
+}
+
+func (l *attachmentList) Scan(src any) error {
+	var data []byte
+	switch v := src.(type) {
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return fmt.Errorf("can't scan %T into attachments", src)
+	}
+
+	// the keys are hidden from the json of Attachment, read them next to it
+	var rows []struct {
+		Attachment
+		BlobKey      string `json:"blob_key"`
+		ThumbnailKey string `json:"thumbnail_key"`
+	}
+	if err := json.Unmarshal(data, &rows); err != nil {
+		return err
+	}
+
+	attachments := make([]Attachment, len(rows))
+	for i, row := range rows {
+		a := row.Attachment
+		a.BlobKey, a.ThumbnailKey = row.BlobKey, row.ThumbnailKey
+		a.setURLs(l.blobURL)
+		attachments[i] = a
+	}
+	*l.dest = attachments
+
+	return nil
+}
+
+// Create adds attachments to the post, all of them or none.
+// it returns ErrTooManyAttachments when the post would end up with more than MaxAttachmentsPerPost.
+func (s *AttachmentStore) Create(ctx context.Context, postID int64, attachments []*Attachment) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return withTeransaction(s.db, ctx, func(tx *sql.Tx) error {
+		// lock the post so two uploads at once can't both pass the limit
+		var count int
+		query := `
+			SELECT (SELECT COUNT(*) FROM attachments WHERE post_id = p.id)
+			FROM posts AS p
+			WHERE p.id = $1
+			FOR UPDATE
+		`
+		if err := tx.QueryRowContext(ctx, query, postID).Scan(&count); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrNotFound
+			}
+			return err
+		}
+		if count+len(attachments) > MaxAttachmentsPerPost {
+			return ErrTooManyAttachments
+		}
+
+		query = `
+			INSERT INTO attachments (post_id, user_id, blob_key, thumbnail_key, content_type, size, width, height)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+			RETURNING id, created_at
+		`
+		for _, a := range attachments {
+			a.PostID = postID
+			err := tx.QueryRowContext(
+				ctx,
+				query,
+				a.PostID,
+				a.UserID,
+				a.BlobKey,
+				a.ThumbnailKey,
+				a.ContentType,
+				a.Size,
+				a.Width,
+				a.Height,
+			).Scan(&a.ID, &a.CreatedAt)
+			if err != nil {
+				return err
+			}
+			a.setURLs(s.blobURL)
+		}
+
+		return nil
+	})
+}
+
+// ListByPost returns the attachments of the post with their blob keys, oldest first
+func (s *AttachmentStore) ListByPost(ctx context.Context, postID int64) ([]Attachment, error) {
+	query := `
+		SELECT id, post_id, user_id, blob_key, thumbnail_key, content_type, size, width, height, created_at
+		FROM attachments
+		WHERE post_id = $1
+		ORDER BY id
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, postID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	attachments := []Attachment{}
+	for rows.Next() {
+		var a Attachment
+		err := rows.Scan(
+			&a.ID,
+			&a.PostID,
+			&a.UserID,
+			&a.BlobKey,
+			&a.ThumbnailKey,
+			&a.ContentType,
+			&a.Size,
+			&a.Width,
+			&a.Height,
+			&a.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		a.setURLs(s.blobURL)
+		attachments = append(attachments, a)
+	}
+
+	return attachments, rows.Err()
+}
+
+// Delete removes the attachment of the post and returns it, so the caller can delete its blobs
+func (s *AttachmentStore) Delete(ctx context.Context, postID, id int64) (*Attachment, error) {
+	query := `
+		DELETE FROM attachments
+		WHERE id = $1 AND post_id = $2
+		RETURNING id, post_id, user_id, blob_key, thumbnail_key, content_type, size, width, height, created_at
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	a := &Attachment{}
+	err := s.db.QueryRowContext(ctx, query, id, postID).Scan(
+		&a.ID,
+		&a.PostID,
+		&a.UserID,
+		&a.BlobKey,
+		&a.ThumbnailKey,
+		&a.ContentType,
+		&a.Size,
+		&a.Width,
+		&a.Height,
+		&a.CreatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+	a.setURLs(s.blobURL)
+
+	return a, nil
+}
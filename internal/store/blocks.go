@@ -0,0 +1,92 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+)
+
+// blocks and mutes database
+type BlockStore struct {
+	db *sql.DB
+}
+
+// hiddenAuthorsOf is a subquery of every user the viewer ($1) shouldn't see:
+// the ones they blocked or muted and the ones who blocked them
+const hiddenAuthorsOf = `
+	SELECT blocked_id FROM blocks WHERE blocker_id = $1
+	UNION SELECT blocker_id FROM blocks WHERE blocked_id = $1
+	UNION SELECT muted_id FROM mutes WHERE muter_id = $1`
+
+// blockedUsersOf is a subquery of the users the viewer ($1) blocked or was blocked by
+const blockedUsersOf = `
+	SELECT blocked_id FROM blocks WHERE blocker_id = $1
+	UNION SELECT blocker_id FROM blocks WHERE blocked_id = $1`
+
+// blockedEitherWay is true when $1 and $2 blocked each other in any direction
+const blockedEitherWay = `
+	EXISTS (
+		SELECT 1 FROM blocks
+		WHERE (blocker_id = $1 AND blocked_id = $2) OR (blocker_id = $2 AND blocked_id = $1)
+	)`
+
+// Block blocks the user and removes the follows between the two of them
+func (s *BlockStore) Block(ctx context.Context, blockerID, blockedID int64) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return withTeransaction(s.db, ctx, func(tx *sql.Tx) error {
+		query := `INSERT INTO blocks (blocker_id, blocked_id) VALUES ($1, $2) ON CONFLICT DO NOTHING`
+		if _, err := tx.ExecContext(ctx, query, blockerID, blockedID); err != nil {
+			return err
+		}
+
+		query = `
+			DELETE FROM followers
+			WHERE (user_id = $1 AND follower_id = $2) OR (user_id = $2 AND follower_id = $1)
+		`
+		_, err := tx.ExecContext(ctx, query, blockerID, blockedID)
+		return err
+	})
+}
+
+func (s *BlockStore) Unblock(ctx context.Context, blockerID, blockedID int64) error {
+	query := `DELETE FROM blocks WHERE blocker_id = $1 AND blocked_id = $2`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, blockerID, blockedID)
+	return err
+}
+
+func (s *BlockStore) Mute(ctx context.Context, muterID, mutedID int64) error {
+	query := `INSERT INTO mutes (muter_id, muted_id) VALUES ($1, $2) ON CONFLICT DO NOTHING`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, muterID, mutedID)
+	return err
+}
+
+func (s *BlockStore) Unmute(ctx context.Context, muterID, mutedID int64) error {
+	query := `DELETE FROM mutes WHERE muter_id = $1 AND muted_id = $2`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, muterID, mutedID)
+	return err
+}
+
+// IsBlocked is true when either user blocked the other
+func (s *BlockStore) IsBlocked(ctx context.Context, userID, otherID int64) (bool, error) {
+	query := `SELECT ` + blockedEitherWay
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var blocked bool
+	err := s.db.QueryRowContext(ctx, query, userID, otherID).Scan(&blocked)
+	return blocked, err
+}
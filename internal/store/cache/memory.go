@@ -0,0 +1,117 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/sirUnchained/udemy-backend-course/internal/store"
+)
+
+// lru is a small thread safe LRU cache where every entry also has a TTL
+type lru[K comparable, V any] struct {
+	mu       sync.Mutex
+	capacity int
+	ttl      time.Duration
+	ll       *list.List
+	items    map[K]*list.Element
+}
+
+type lruEntry[K comparable, V any] struct {
+	key       K
+	value     V
+	expiresAt time.Time
+}
+
+func newLRU[K comparable, V any](capacity int, ttl time.Duration) *lru[K, V] {
+	return &lru[K, V]{
+		capacity: capacity,
+		ttl:      ttl,
+		ll:       list.New(),
+		items:    make(map[K]*list.Element),
+	}
+}
+
+func (c *lru[K, V]) get(key K) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var zero V
+	el, ok := c.items[key]
+	if !ok {
+		return zero, false
+	}
+
+	entry := el.Value.(*lruEntry[K, V])
+	if c.ttl > 0 && time.Now().After(entry.expiresAt) {
+		c.removeElement(el)
+		return zero, false
+	}
+
+	c.ll.MoveToFront(el)
+	return entry.value, true
+}
+
+func (c *lru[K, V]) set(key K, value V) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expiresAt := time.Now().Add(c.ttl)
+
+	if el, ok := c.items[key]; ok {
+		entry := el.Value.(*lruEntry[K, V])
+		entry.value = value
+		entry.expiresAt = expiresAt
+		c.ll.MoveToFront(el)
+		return
+	}
+
+	el := c.ll.PushFront(&lruEntry[K, V]{key: key, value: value, expiresAt: expiresAt})
+	c.items[key] = el
+
+	// capacity <= 0 means we never evict
+	if c.capacity > 0 && c.ll.Len() > c.capacity {
+		c.removeElement(c.ll.Back())
+	}
+}
+
+func (c *lru[K, V]) delete(key K) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		c.removeElement(el)
+	}
+}
+
+func (c *lru[K, V]) removeElement(el *list.Element) {
+	c.ll.Remove(el)
+	delete(c.items, el.Value.(*lruEntry[K, V]).key)
+}
+
+type MemoryUserStore struct {
+	lru *lru[int64, *store.User]
+}
+
+func (s *MemoryUserStore) Get(ctx context.Context, userID int64) (*store.User, error) {
+	user, ok := s.lru.get(userID)
+	if !ok {
+		return nil, nil
+	}
+
+	// hand out a copy so callers can't change what is inside the cache
+	u := *user
+	return &u, nil
+}
+
+func (s *MemoryUserStore) Set(ctx context.Context, user *store.User) error {
+	u := *user
+	s.lru.set(user.ID, &u)
+	return nil
+}
+
+func (s *MemoryUserStore) Delete(ctx context.Context, userID int64) error {
+	s.lru.delete(userID)
+	return nil
+}
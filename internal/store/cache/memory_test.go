@@ -0,0 +1,150 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirUnchained/udemy-backend-course/internal/store"
+)
+
+func TestLRUEvictsTheLeastRecentlyUsed(t *testing.T) {
+	c := newLRU[string, int](2, time.Minute)
+
+	c.set("a", 1)
+	c.set("b", 2)
+
+	// reading a makes b the oldest
+	if v, ok := c.get("a"); !ok || v != 1 {
+		t.Fatalf("get a = %v, %v, want 1, true", v, ok)
+	}
+
+	c.set("c", 3)
+
+	if _, ok := c.get("b"); ok {
+		t.Error("b should have been evicted")
+	}
+	for key, want := range map[string]int{"a": 1, "c": 3} {
+		if v, ok := c.get(key); !ok || v != want {
+			t.Errorf("get %s = %v, %v, want %v, true", key, v, ok, want)
+		}
+	}
+}
+
+func TestLRUSetUpdates(t *testing.T) {
+	c := newLRU[string, int](2, time.Minute)
+
+	c.set("a", 1)
+	c.set("b", 2)
+	c.set("a", 10) // an update, not a new entry, and a is the newest again
+	c.set("c", 3)
+
+	if v, ok := c.get("a"); !ok || v != 10 {
+		t.Errorf("get a = %v, %v, want 10, true", v, ok)
+	}
+	if _, ok := c.get("b"); ok {
+		t.Error("b should have been evicted")
+	}
+	if c.ll.Len() != 2 || len(c.items) != 2 {
+		t.Errorf("len = %d/%d, want 2", c.ll.Len(), len(c.items))
+	}
+}
+
+func TestLRUExpires(t *testing.T) {
+	c := newLRU[string, int](10, time.Millisecond*20)
+
+	c.set("a", 1)
+	if _, ok := c.get("a"); !ok {
+		t.Fatal("a should be there before the ttl")
+	}
+
+	time.Sleep(time.Millisecond * 30)
+
+	if _, ok := c.get("a"); ok {
+		t.Error("a should have expired")
+	}
+	if len(c.items) != 0 {
+		t.Errorf("expired entries left: %d", len(c.items))
+	}
+}
+
+func TestLRUNoCapacityNoTTL(t *testing.T) {
+	c := newLRU[int, int](0, 0)
+
+	for i := 0; i < 100; i++ {
+		c.set(i, i)
+	}
+	for i := 0; i < 100; i++ {
+		if v, ok := c.get(i); !ok || v != i {
+			t.Fatalf("get %d = %v, %v", i, v, ok)
+		}
+	}
+}
+
+func TestLRUDelete(t *testing.T) {
+	c := newLRU[string, int](2, time.Minute)
+
+	c.set("a", 1)
+	c.delete("a")
+	c.delete("missing")
+
+	if _, ok := c.get("a"); ok {
+		t.Error("a should be gone")
+	}
+	if c.ll.Len() != 0 {
+		t.Errorf("list len = %d, want 0", c.ll.Len())
+	}
+}
+
+func TestLRUConcurrent(t *testing.T) {
+	c := newLRU[int, int](50, time.Minute)
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				key := (g*1000 + i) % 100
+				c.set(key, i)
+				c.get(key)
+				if i%10 == 0 {
+					c.delete(key)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	if c.ll.Len() > 50 || c.ll.Len() != len(c.items) {
+		t.Errorf("list has %d entries and the map %d, want at most 50 and equal", c.ll.Len(), len(c.items))
+	}
+}
+
+func TestMemoryUserStoreCopies(t *testing.T) {
+	s := &MemoryUserStore{lru: newLRU[int64, *store.User](10, time.Minute)}
+	ctx := context.Background()
+
+	user := &store.User{ID: 1, UserName: "bob"}
+	if err := s.Set(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+	user.UserName = "changed after set"
+
+	got, err := s.Get(ctx, 1)
+	if err != nil || got == nil {
+		t.Fatalf("get = %v, %v", got, err)
+	}
+	got.UserName = "changed after get"
+
+	again, _ := s.Get(ctx, 1)
+	if again.UserName != "bob" {
+		t.Errorf("username = %q, the cached user was changed from outside", again.UserName)
+	}
+
+	// a miss is nil, nil
+	if got, err := s.Get(ctx, 2); got != nil || err != nil {
+		t.Errorf("miss = %v, %v, want nil, nil", got, err)
+	}
+}
@@ -0,0 +1,66 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/sirUnchained/udemy-backend-course/internal/store"
+)
+
+// redisClient is the part of go-redis we use, anything that talks
+// the redis protocol (redis, valkey, dragonfly, ...) is fine
+type redisClient interface {
+	Get(ctx context.Context, key string) *redis.StringCmd
+	Set(ctx context.Context, key string, value any, expiration time.Duration) *redis.StatusCmd
+	Del(ctx context.Context, keys ...string) *redis.IntCmd
+}
+
+func NewRedisClient(addr, pw string, db int) *redis.Client {
+	return redis.NewClient(&redis.Options{
+		Addr:     addr,
+		Password: pw,
+		DB:       db,
+	})
+}
+
+type RedisUserStore struct {
+	rdb redisClient
+	ttl time.Duration
+}
+
+func (s *RedisUserStore) Get(ctx context.Context, userID int64) (*store.User, error) {
+	data, err := s.rdb.Get(ctx, userKey(userID)).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	user := &store.User{}
+	if err := json.Unmarshal([]byte(data), user); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+func (s *RedisUserStore) Set(ctx context.Context, user *store.User) error {
+	data, err := json.Marshal(user)
+	if err != nil {
+		return err
+	}
+
+	return s.rdb.Set(ctx, userKey(user.ID), data, s.ttl).Err()
+}
+
+func (s *RedisUserStore) Delete(ctx context.Context, userID int64) error {
+	return s.rdb.Del(ctx, userKey(userID)).Err()
+}
+
+func userKey(userID int64) string {
+	return fmt.Sprintf("user-%d", userID)
+}
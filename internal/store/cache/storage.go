@@ -0,0 +1,33 @@
+package cache
+
+import (
+	"context"
+	"time"
+
+	"github.com/sirUnchained/udemy-backend-course/internal/store"
+)
+
+// this is our cache storage, it has the same shape as store.Storage
+// so handlers can ask the cache first and fall back to the database.
+// a miss is reported as (nil, nil), errors are only real failures.
+type Storage struct {
+	Users interface {
+		Get(context.Context, int64) (*store.User, error)
+		Set(context.Context, *store.User) error
+		Delete(context.Context, int64) error
+	}
+}
+
+// NewMemoryStorage keeps everything inside the process, capacity is the
+// maximum number of users we keep before evicting the least recently used one
+func NewMemoryStorage(capacity int, ttl time.Duration) Storage {
+	return Storage{
+		Users: &MemoryUserStore{lru: newLRU[int64, *store.User](capacity, ttl)},
+	}
+}
+
+func NewRedisStorage(rdb redisClient, ttl time.Duration) Storage {
+	return Storage{
+		Users: &RedisUserStore{rdb: rdb, ttl: ttl},
+	}
+}
@@ -3,7 +3,18 @@ package store
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"time"
+
+	"github.com/sirUnchained/udemy-backend-course/internal/notifications"
+)
+
+// replies deeper than this are rejected, the ui can't indent them forever
+const MaxCommentDepth = 5
+
+var (
+	ErrInvalidParent  = errors.New("parent comment does not exist on this post")
+	ErrCommentTooDeep = errors.New("comment thread is too deep")
 )
 
 // comment database
@@ -11,59 +22,332 @@ type CommentStore struct {
 	db *sql.DB
 }
 
-// comment model
+// comment model, deleted comments keep their place in the thread but lose their content
 type Comment struct {
-	ID        int64     `json:"id"`
-	UserID    int64     `json:"user_id"`
-	PostID    int64     `json:"post_id"`
-	Content   string    `json:"content"`
-	CreatedAt time.Time `json:"created_at"`
-	User      User      `json:"user"`
+	ID        int64      `json:"id"`
+	UserID    int64      `json:"user_id"`
+	PostID    int64      `json:"post_id"`
+	ParentID  *int64     `json:"parent_id"`
+	Depth     int        `json:"depth"`
+	Content   string     `json:"content"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	User      Author     `json:"user"`
+	Mentions  []string   `json:"-"` // usernames to record as mentioned when the comment is created
+}
+
+// CommentPage is one page of a post's comments
+type CommentPage struct {
+	Comments   []Comment
+	NextCursor string
+}
+
+// the columns every comment query reads, content is hidden once a comment is deleted
+const commentColumns = `c.id, c.post_id, c.user_id, c.parent_id, c.depth,
+	CASE WHEN c.deleted_at IS NULL THEN c.content ELSE '' END,
+	c.created_at, c.updated_at, c.deleted_at, users.username, users.id`
+
+func scanComment(row interface{ Scan(...any) error }, c *Comment) error {
+	return row.Scan(
+		&c.ID,
+		&c.PostID,
+		&c.UserID,
+		&c.ParentID,
+		&c.Depth,
+		&c.Content,
+		&c.CreatedAt,
+		&c.UpdatedAt,
+		&c.DeletedAt,
+		&c.User.UserName,
+		&c.User.ID,
+	)
 }
 
 // CRUD
 func (s *CommentStore) Create(ctx context.Context, comment *Comment) error {
-	query := `INSERT INTO comments (user_id, post_id, content) VALUES ($1, $2, $3) RETURNING id, created_at`
-
-	err := s.db.QueryRowContext(ctx, query,
-		comment.UserID,
-		comment.PostID,
-		comment.Content,
-	).Scan(
-		&comment.ID,
-		&comment.CreatedAt,
-	)
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return withTeransaction(s.db, ctx, func(tx *sql.Tx) error {
+		// people can't comment on the posts of someone who blocked them
+		var postAuthorID, parentAuthorID int64
+		var blocked bool
+		query := `
+			SELECT p.user_id, EXISTS (SELECT 1 FROM blocks WHERE blocker_id = p.user_id AND blocked_id = $2)
+			FROM posts AS p WHERE p.id = $1
+		`
+		if err := tx.QueryRowContext(ctx, query, comment.PostID, comment.UserID).Scan(&postAuthorID, &blocked); err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrNotFound
+			default:
+				return err
+			}
+		}
+		if blocked {
+			return ErrBlocked
+		}
+
+		comment.Depth = 0
+		if comment.ParentID != nil {
+			// lock the parent so it can't be deleted while we reply to it
+			var deleted bool
+			query = `SELECT user_id, depth, deleted_at IS NOT NULL FROM comments WHERE id = $1 AND post_id = $2 FOR SHARE`
+			err := tx.QueryRowContext(ctx, query, *comment.ParentID, comment.PostID).Scan(&parentAuthorID, &comment.Depth, &deleted)
+			if err != nil {
+				switch {
+				case errors.Is(err, sql.ErrNoRows):
+					return ErrInvalidParent
+				default:
+					return err
+				}
+			}
+			if deleted {
+				return ErrInvalidParent
+			}
+
+			comment.Depth++
+			if comment.Depth > MaxCommentDepth {
+				return ErrCommentTooDeep
+			}
+		}
+
+		query = `
+			INSERT INTO comments (user_id, post_id, parent_id, depth, content)
+			VALUES ($1, $2, $3, $4, $5)
+			RETURNING id, created_at, updated_at
+		`
+		err := tx.QueryRowContext(ctx, query,
+			comment.UserID,
+			comment.PostID,
+			comment.ParentID,
+			comment.Depth,
+			comment.Content,
+		).Scan(
+			&comment.ID,
+			&comment.CreatedAt,
+			&comment.UpdatedAt,
+		)
+		if err != nil {
+			return err
+		}
 
-	return err
+		// a reply to the post author's own comment only tells them once
+		notified := []int64{}
+		if comment.ParentID != nil {
+			err := notifications.Insert(ctx, tx, &notifications.Notification{
+				UserID:    parentAuthorID,
+				ActorID:   comment.UserID,
+				Kind:      notifications.KindReply,
+				PostID:    &comment.PostID,
+				CommentID: &comment.ID,
+			})
+			if err != nil {
+				return err
+			}
+			notified = append(notified, parentAuthorID)
+		}
+
+		if comment.ParentID == nil || parentAuthorID != postAuthorID {
+			err := notifications.Insert(ctx, tx, &notifications.Notification{
+				UserID:    postAuthorID,
+				ActorID:   comment.UserID,
+				Kind:      notifications.KindComment,
+				PostID:    &comment.PostID,
+				CommentID: &comment.ID,
+			})
+			if err != nil {
+				return err
+			}
+			notified = append(notified, postAuthorID)
+		}
+
+		return insertMentions(ctx, tx, comment.UserID, comment.PostID, &comment.ID, comment.Mentions, notified...)
+	})
+}
+
+// GetById returns a comment that is not deleted
+func (s *CommentStore) GetById(ctx context.Context, id int64) (*Comment, error) {
+	query := `SELECT ` + commentColumns + ` FROM comments AS c
+				JOIN users ON users.id = c.user_id
+				WHERE c.id = $1 AND c.deleted_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	comment := &Comment{}
+	if err := scanComment(s.db.QueryRowContext(ctx, query, id), comment); err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return comment, nil
 }
 
-func (s *CommentStore) GetCommentsByPostId(ctx context.Context, postID int64) ([]Comment, error) {
-	query := `SELECT c.id, c.post_id, c.user_id, c.content, c.created_at, users.username, users.id FROM comments AS c
+// GetCommentsByPostId leaves out the comments of users hidden from the viewer
+func (s *CommentStore) GetCommentsByPostId(ctx context.Context, postID, viewerID int64) ([]Comment, error) {
+	query := `SELECT ` + commentColumns + ` FROM comments AS c
 				JOIN users ON users.id = c.user_id
-				WHERE post_id = $1 
+				WHERE post_id = $2 AND c.user_id NOT IN (` + hiddenAuthorsOf + `)
 				ORDER BY c.created_at DESC;`
 
-	rows, err := s.db.QueryContext(ctx, query, postID)
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, viewerID, postID)
+	if err != nil {
+		return nil, err
+	}
 	defer rows.Close()
 
 	comments := []Comment{}
 	for rows.Next() {
 		var c Comment
-		c.User = User{}
-		err := rows.Scan(
-			&c.ID,
-			&c.PostID,
-			&c.UserID,
-			&c.Content,
-			&c.CreatedAt,
-			&c.User.UserName,
-			&c.User.ID,
-		)
-		if err != nil {
+		if err := scanComment(rows, &c); err != nil {
 			return nil, err
 		}
 		comments = append(comments, c)
 	}
 
-	return comments, err
+	return comments, rows.Err()
+}
+
+// ListByPost pages through the comments of a post oldest first,
+// replies come with their parent_id so clients can build the tree.
+// comments of users hidden from the viewer are left out.
+func (s *CommentStore) ListByPost(ctx context.Context, postID, viewerID int64, q PaginatedQuery) (*CommentPage, error) {
+	query := `SELECT ` + commentColumns + ` FROM comments AS c
+				JOIN users ON users.id = c.user_id
+				WHERE
+					c.post_id = $2 AND
+					c.user_id NOT IN (` + hiddenAuthorsOf + `) AND
+					($3::timestamptz IS NULL OR (c.created_at, c.id) > ($3, $4))
+				ORDER BY c.created_at ASC, c.id ASC
+				LIMIT $5`
+
+	var cursorAt any
+	var cursorID int64
+	if q.Cursor != nil {
+		cursorAt, cursorID = q.Cursor.CreatedAt, q.Cursor.ID
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, viewerID, postID, cursorAt, cursorID, q.Limit+1)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	comments := []Comment{}
+	for rows.Next() {
+		var c Comment
+		if err := scanComment(rows, &c); err != nil {
+			return nil, err
+		}
+		comments = append(comments, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	page := &CommentPage{Comments: comments}
+	if len(comments) > q.Limit {
+		page.Comments = comments[:q.Limit]
+		last := page.Comments[q.Limit-1]
+		page.NextCursor = Cursor{CreatedAt: last.CreatedAt, ID: last.ID, Sort: "asc"}.Encode()
+	}
+
+	return page, nil
+}
+
+func (s *CommentStore) Update(ctx context.Context, comment *Comment) error {
+	query := `UPDATE comments SET content = $1, updated_at = NOW() WHERE id = $2 AND deleted_at IS NULL RETURNING updated_at`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	err := s.db.QueryRowContext(ctx, query, comment.Content, comment.ID).Scan(&comment.UpdatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrNotFound
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// SoftDelete marks the comment deleted, its replies stay where they are
+func (s *CommentStore) SoftDelete(ctx context.Context, id int64) error {
+	query := `UPDATE comments SET deleted_at = NOW() WHERE id = $1 AND deleted_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	result, err := s.db.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// CreateBatch inserts all comments with a single query, used by the seeder
+func (s *CommentStore) CreateBatch(ctx context.Context, tx *sql.Tx, comments []*Comment) error {
+	if len(comments) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	ids, err := reserveIDs(ctx, tx, "comments", len(comments))
+	if err != nil {
+		return err
+	}
+
+	query := `INSERT INTO comments (id, user_id, post_id, content) VALUES ` + placeholders(len(comments), 4) + ` RETURNING id, created_at`
+
+	byID := make(map[int64]*Comment, len(comments))
+	args := make([]any, 0, len(comments)*4)
+	for i, c := range comments {
+		c.ID = ids[i]
+		byID[c.ID] = c
+		args = append(args, c.ID, c.UserID, c.PostID, c.Content)
+	}
+
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		var createdAt time.Time
+		if err := rows.Scan(&id, &createdAt); err != nil {
+			return err
+		}
+		byID[id].CreatedAt = createdAt
+	}
+
+	return rows.Err()
 }
@@ -0,0 +1,287 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// feed modes
+const (
+	FeedModeLatest = "latest"
+	FeedModeTop    = "top"
+)
+
+// the top feed ranks the newest posts of this window, older posts would score ~0 anyway
+const (
+	topFeedWindow     = time.Hour * 24 * 7
+	topFeedCandidates = 500
+	affinityWindow    = time.Hour * 24 * 30
+)
+
+// FeedPage is one page of the feed, the cursors are empty when there is nothing more that way
+type FeedPage struct {
+	Posts      []PostWithMetadata
+	NextCursor string
+	PrevCursor string
+}
+
+// feedColumns and feedFilters are shared by both feed modes, the filters use $1 to $5.
+// authors the viewer blocked, muted or was blocked by never show up.
+// comments_count uses $1 too, it counts what the viewer sees in the thread without the deleted ones.
+const feedColumns = `
+	p.id, p.user_id, p.title, p.content, p.created_at, p.version, p.tags,
+	u.username,
+	(
+		SELECT COUNT(*) FROM comments AS c
+		WHERE c.post_id = p.id AND c.deleted_at IS NULL AND c.user_id NOT IN (` + hiddenAuthorsOf + `)
+	) AS comments_count,
+	` + reactionSummaryColumns + `,
+	` + attachmentColumns
+
+const feedFilters = `
+	(p.user_id = $1 OR p.user_id IN (SELECT user_id FROM followers WHERE follower_id = $1)) AND
+	p.user_id NOT IN (` + hiddenAuthorsOf + `) AND
+	($2::text = '' OR p.search_vector @@ websearch_to_tsquery('english', $2)) AND
+	(COALESCE(cardinality($3::text[]), 0) = 0 OR p.tags @> $3) AND
+	($4::timestamptz IS NULL OR p.created_at >= $4) AND
+	($5::timestamptz IS NULL OR p.created_at <= $5)`
+
+func feedFilterArgs(id int64, fq PaginatedFeedQuery) []any {
+	return []any{id, fq.Search, pq.Array(fq.Tags), nullTime(fq.Since), nullTime(fq.Until)}
+}
+
+// GetUserFeed returns the posts of the user and the users they follow,
+// newest first or ranked by FeedScorer depending on fq.Mode
+func (s *PostStore) GetUserFeed(ctx context.Context, id int64, fq PaginatedFeedQuery) (*FeedPage, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	if fq.Mode == FeedModeTop {
+		return s.getTopFeed(ctx, id, fq)
+	}
+	return s.getLatestFeed(ctx, id, fq)
+}
+
+// getLatestFeed pages with (created_at, id) keyset cursors instead of offsets
+func (s *PostStore) getLatestFeed(ctx context.Context, id int64, fq PaginatedFeedQuery) (*FeedPage, error) {
+	// the comparison and order are picked from a fixed set, never from user input
+	backwards := fq.Cursor != nil && fq.Cursor.Prev
+	op, order := "<", "DESC"
+	if (fq.Sort == "asc") != backwards {
+		op, order = ">", "ASC"
+	}
+
+	query := `
+	SELECT ` + feedColumns + `
+	FROM posts AS p
+	JOIN users AS u ON u.id = p.user_id
+	WHERE ` + feedFilters + ` AND
+		($6::timestamptz IS NULL OR (p.created_at, p.id) ` + op + ` ($6, $7))
+	ORDER BY p.created_at ` + order + `, p.id ` + order + `
+	LIMIT $8;
+	`
+
+	var cursorAt any
+	var cursorID int64
+	if fq.Cursor != nil {
+		cursorAt, cursorID = fq.Cursor.CreatedAt, fq.Cursor.ID
+	}
+
+	// one extra row tells us if there is another page
+	args := append(feedFilterArgs(id, fq), cursorAt, cursorID, fq.Limit+1)
+	feed, err := queryFeed(ctx, s.db, s.blobURL, query, args, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	hasMore := len(feed) > fq.Limit
+	if hasMore {
+		feed = feed[:fq.Limit]
+	}
+
+	// walking backwards we read the rows in reverse, flip them back
+	if backwards {
+		for i, j := 0, len(feed)-1; i < j; i, j = i+1, j-1 {
+			feed[i], feed[j] = feed[j], feed[i]
+		}
+	}
+
+	page := &FeedPage{Posts: feed}
+	if len(feed) == 0 {
+		return page, nil
+	}
+
+	first, last := feed[0], feed[len(feed)-1]
+	if hasMore || backwards {
+		page.NextCursor = Cursor{CreatedAt: last.CreatedAt, ID: last.ID, Sort: fq.Sort}.Encode()
+	}
+	if (backwards && hasMore) || (!backwards && fq.Cursor != nil) {
+		page.PrevCursor = Cursor{CreatedAt: first.CreatedAt, ID: first.ID, Sort: fq.Sort, Prev: true}.Encode()
+	}
+
+	return page, nil
+}
+
+// getTopFeed scores the recent candidates as of one fixed time and pages over
+// (score, id). the cursor keeps that time so every page ranks with the same clock.
+func (s *PostStore) getTopFeed(ctx context.Context, id int64, fq PaginatedFeedQuery) (*FeedPage, error) {
+	asOf := time.Now().UTC()
+	if fq.Cursor != nil {
+		asOf = fq.Cursor.AsOf
+	}
+
+	// affinity is how often the viewer commented on or reacted to the author lately
+	query := `
+	WITH interactions AS (
+		SELECT post_id FROM comments
+		WHERE user_id = $1 AND deleted_at IS NULL AND created_at > $7
+		UNION ALL
+		SELECT post_id FROM reactions
+		WHERE user_id = $1 AND created_at > $7
+	), affinity AS (
+		SELECT ip.user_id AS author_id, COUNT(*) AS interactions
+		FROM interactions AS i
+		JOIN posts AS ip ON ip.id = i.post_id
+		WHERE ip.user_id <> $1
+		GROUP BY ip.user_id
+	)
+	SELECT ` + feedColumns + `,
+		(SELECT COALESCE(SUM(rc.count), 0) FROM post_reaction_counts AS rc WHERE rc.post_id = p.id) AS reactions_count,
+		COALESCE(a.interactions, 0) AS affinity
+	FROM posts AS p
+	JOIN users AS u ON u.id = p.user_id
+	LEFT JOIN affinity AS a ON a.author_id = p.user_id
+	WHERE ` + feedFilters + ` AND
+		p.created_at <= $6 AND
+		p.created_at > $8
+	ORDER BY p.created_at DESC, p.id DESC
+	LIMIT $9;
+	`
+
+	args := append(feedFilterArgs(id, fq),
+		asOf,
+		asOf.Add(-affinityWindow),
+		asOf.Add(-topFeedWindow),
+		topFeedCandidates,
+	)
+
+	type engagement struct{ reactions, affinity int }
+	var engagements []*engagement
+	feed, err := queryFeed(ctx, s.db, s.blobURL, query, args, func(p *PostWithMetadata) []any {
+		e := &engagement{}
+		engagements = append(engagements, e)
+		return []any{&e.reactions, &e.affinity}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// scoring happens outside of postgres so FeedScorer stays plain go
+	scored := make([]scoredPost, len(feed))
+	for i, p := range feed {
+		signals := FeedSignals{
+			Comments:  p.CommentsCount,
+			Reactions: engagements[i].reactions,
+			Affinity:  engagements[i].affinity,
+			CreatedAt: p.CreatedAt,
+		}
+		scored[i] = scoredPost{post: p, score: DefaultFeedScorer.Score(signals, asOf)}
+	}
+	sort.SliceStable(scored, func(i, j int) bool {
+		return scored[i].ranksBefore(scored[j].score, scored[j].post.ID)
+	})
+
+	// skip everything up to and including the cursor's post
+	start := 0
+	if fq.Cursor != nil {
+		for start < len(scored) && !scored[start].ranksAfterCursor(fq.Cursor) {
+			start++
+		}
+	}
+	scored = scored[start:]
+
+	page := &FeedPage{Posts: []PostWithMetadata{}}
+	for i := 0; i < len(scored) && i < fq.Limit; i++ {
+		page.Posts = append(page.Posts, scored[i].post)
+	}
+
+	if len(scored) > fq.Limit {
+		last := scored[fq.Limit-1]
+		page.NextCursor = Cursor{
+			CreatedAt: last.post.CreatedAt,
+			ID:        last.post.ID,
+			Sort:      "desc",
+			Mode:      FeedModeTop,
+			Score:     last.score,
+			AsOf:      asOf,
+		}.Encode()
+	}
+
+	return page, nil
+}
+
+type scoredPost struct {
+	post  PostWithMetadata
+	score float64
+}
+
+// ranksBefore orders by score and then id, both descending, so ties are stable
+func (sp scoredPost) ranksBefore(score float64, id int64) bool {
+	if sp.score != score {
+		return sp.score > score
+	}
+	return sp.post.ID > id
+}
+
+// ranksAfterCursor is true for posts that belong to the pages after the cursor
+func (sp scoredPost) ranksAfterCursor(c *Cursor) bool {
+	cursor := scoredPost{post: PostWithMetadata{Post: Post{ID: c.ID}}, score: c.Score}
+	return cursor.ranksBefore(sp.score, sp.post.ID)
+}
+
+// queryFeed runs a query that selects feedColumns, extra returns scan targets for any columns after them
+func queryFeed(ctx context.Context, db *sql.DB, blobURL URLFunc, query string, args []any, extra func(*PostWithMetadata) []any) ([]PostWithMetadata, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	feed := []PostWithMetadata{}
+	for rows.Next() {
+		var p PostWithMetadata
+
+		reactions, fillReactions := reactionSummaryScanner(&p.ReactionSummary)
+		dest := append([]any{
+			&p.ID,
+			&p.UserID,
+			&p.Title,
+			&p.Content,
+			&p.CreatedAt,
+			&p.Version,
+			pq.Array(&p.Tags),
+			&p.User.UserName,
+			&p.CommentsCount,
+		}, reactions...)
+		dest = append(dest, scanAttachments(&p.Attachments, blobURL))
+		if extra != nil {
+			dest = append(dest, extra(&p)...)
+		}
+
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		p.User.ID = p.UserID
+		if err := fillReactions(); err != nil {
+			return nil, err
+		}
+
+		feed = append(feed, p)
+	}
+
+	return feed, rows.Err()
+}
@@ -0,0 +1,47 @@
+package store
+
+import (
+	"math"
+	"time"
+)
+
+// FeedSignals is everything FeedScorer knows about a post
+type FeedSignals struct {
+	Comments  int
+	Reactions int
+	Affinity  int // how many times the viewer interacted with the author lately
+	CreatedAt time.Time
+}
+
+// FeedScorer ranks posts for the top feed. it has no database in it so it is
+// easy to tune: engagement grows with the log of each signal and the whole score
+// halves every HalfLife.
+type FeedScorer struct {
+	CommentWeight  float64
+	ReactionWeight float64
+	AffinityWeight float64
+	HalfLife       time.Duration
+}
+
+var DefaultFeedScorer = FeedScorer{
+	CommentWeight:  2,
+	ReactionWeight: 1,
+	AffinityWeight: 3,
+	HalfLife:       time.Hour * 12,
+}
+
+// Score is always positive, a brand new post nobody touched yet scores 1
+func (s FeedScorer) Score(sig FeedSignals, now time.Time) float64 {
+	engagement := 1 +
+		s.CommentWeight*math.Log1p(float64(max(sig.Comments, 0))) +
+		s.ReactionWeight*math.Log1p(float64(max(sig.Reactions, 0))) +
+		s.AffinityWeight*math.Log1p(float64(max(sig.Affinity, 0)))
+
+	// posts from the future (clock skew) count as brand new
+	age := max(now.Sub(sig.CreatedAt), 0)
+	if s.HalfLife <= 0 {
+		return engagement
+	}
+
+	return engagement * math.Exp2(-age.Hours()/s.HalfLife.Hours())
+}
@@ -0,0 +1,123 @@
+package store
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestFeedScorerScore(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	scorer := FeedScorer{CommentWeight: 2, ReactionWeight: 1, AffinityWeight: 3, HalfLife: time.Hour * 12}
+
+	tests := []struct {
+		name string
+		sig  FeedSignals
+		want float64
+	}{
+		{
+			name: "zero signals, brand new",
+			sig:  FeedSignals{CreatedAt: now},
+			want: 1,
+		},
+		{
+			name: "zero signals, one half life old",
+			sig:  FeedSignals{CreatedAt: now.Add(-time.Hour * 12)},
+			want: 0.5,
+		},
+		{
+			name: "zero signals, two half lives old",
+			sig:  FeedSignals{CreatedAt: now.Add(-time.Hour * 24)},
+			want: 0.25,
+		},
+		{
+			name: "from the future counts as new",
+			sig:  FeedSignals{CreatedAt: now.Add(time.Hour)},
+			want: 1,
+		},
+		{
+			name: "comments",
+			sig:  FeedSignals{Comments: 3, CreatedAt: now},
+			want: 1 + 2*math.Log1p(3),
+		},
+		{
+			name: "reactions",
+			sig:  FeedSignals{Reactions: 3, CreatedAt: now},
+			want: 1 + math.Log1p(3),
+		},
+		{
+			name: "follow boost",
+			sig:  FeedSignals{Affinity: 3, CreatedAt: now},
+			want: 1 + 3*math.Log1p(3),
+		},
+		{
+			name: "everything, decayed",
+			sig:  FeedSignals{Comments: 1, Reactions: 2, Affinity: 4, CreatedAt: now.Add(-time.Hour * 12)},
+			want: (1 + 2*math.Log1p(1) + math.Log1p(2) + 3*math.Log1p(4)) / 2,
+		},
+		{
+			name: "negative counts are ignored",
+			sig:  FeedSignals{Comments: -5, Reactions: -1, Affinity: -2, CreatedAt: now},
+			want: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := scorer.Score(tt.sig, now)
+			if math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("Score = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFeedScorerOrdering(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	s := DefaultFeedScorer
+
+	tests := []struct {
+		name          string
+		higher, lower FeedSignals
+	}{
+		{
+			name:   "newer wins with the same engagement",
+			higher: FeedSignals{Reactions: 5, CreatedAt: now.Add(-time.Hour)},
+			lower:  FeedSignals{Reactions: 5, CreatedAt: now.Add(-time.Hour * 5)},
+		},
+		{
+			name:   "a comment is worth more than a reaction",
+			higher: FeedSignals{Comments: 1, CreatedAt: now},
+			lower:  FeedSignals{Reactions: 1, CreatedAt: now},
+		},
+		{
+			name:   "authors the viewer interacts with are boosted",
+			higher: FeedSignals{Affinity: 1, CreatedAt: now},
+			lower:  FeedSignals{Comments: 1, CreatedAt: now},
+		},
+		{
+			name:   "an old popular post sinks below a fresh one",
+			higher: FeedSignals{CreatedAt: now},
+			lower:  FeedSignals{Comments: 50, Reactions: 200, CreatedAt: now.Add(-time.Hour * 24 * 7)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, l := s.Score(tt.higher, now), s.Score(tt.lower, now)
+			if h <= l {
+				t.Errorf("score %v should be higher than %v", h, l)
+			}
+		})
+	}
+}
+
+func TestFeedScorerNoHalfLife(t *testing.T) {
+	now := time.Now()
+	s := FeedScorer{CommentWeight: 1}
+
+	got := s.Score(FeedSignals{Comments: 1, CreatedAt: now.Add(-time.Hour * 24 * 365)}, now)
+	if want := 1 + math.Log1p(1); math.Abs(got-want) > 1e-9 {
+		t.Errorf("Score = %v, want %v without decay", got, want)
+	}
+}
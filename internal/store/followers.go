@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/lib/pq"
+	"github.com/sirUnchained/udemy-backend-course/internal/notifications"
 )
 
 type FollowStore struct {
@@ -18,17 +19,56 @@ type Follower struct {
 	CreatedAt  time.Time `json:"created_at"`
 }
 
+// Follow returns ErrBlocked when either user blocked the other
 func (s *FollowStore) Follow(ctx context.Context, followerID int64, userID int64) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return withTeransaction(s.db, ctx, func(tx *sql.Tx) error {
+		var blocked bool
+		if err := tx.QueryRowContext(ctx, `SELECT `+blockedEitherWay, followerID, userID).Scan(&blocked); err != nil {
+			return err
+		}
+		if blocked {
+			return ErrBlocked
+		}
+
+		query := `
+			INSERT INTO followers (user_id, follower_id, created_at)
+			VALUES ($1, $2, $3)
+			ON CONFLICT DO NOTHING
+		`
+		result, err := tx.ExecContext(ctx, query, userID, followerID, time.Now().UTC())
+		if err != nil {
+			if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+				return Errconflict
+			}
+			return err
+		}
+
+		// following again someone you already follow doesn't notify them twice
+		if rows, err := result.RowsAffected(); err != nil || rows == 0 {
+			return err
+		}
+
+		return notifications.Insert(ctx, tx, &notifications.Notification{
+			UserID:  userID,
+			ActorID: followerID,
+			Kind:    notifications.KindFollow,
+		})
+	})
+}
+
+func (s *FollowStore) UnFollow(ctx context.Context, followerID int64, userID int64) error {
 	query := `
-		INSERT INTO followers (user_id, follower_id, created_at)
-		VALUES ($1, $2, $3)
-		ON CONFLICT DO NOTHING
+		DELETE FROM followers
+		WHERE user_id = $1 AND follower_id = $2
 	`
 
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
 	defer cancel()
 
-	_, err := s.db.ExecContext(ctx, query, userID, followerID, time.Now().UTC())
+	_, err := s.db.ExecContext(ctx, query, userID, followerID)
 	if err != nil {
 		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
 			return Errconflict
@@ -39,22 +79,223 @@ func (s *FollowStore) Follow(ctx context.Context, followerID int64, userID int64
 	return nil
 }
 
-func (s *FollowStore) UnFollow(ctx context.Context, followerID int64, userID int64) error {
+// FollowBatch inserts all follow relations with a single query, used by the seeder
+func (s *FollowStore) FollowBatch(ctx context.Context, tx *sql.Tx, follows []Follower) error {
+	if len(follows) == 0 {
+		return nil
+	}
+
+	query := `INSERT INTO followers (user_id, follower_id) VALUES ` + placeholders(len(follows), 2) + ` ON CONFLICT DO NOTHING`
+
+	args := make([]any, 0, len(follows)*2)
+	for _, f := range follows {
+		args = append(args, f.UserID, f.FollowerID)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := tx.ExecContext(ctx, query, args...)
+	return err
+}
+
+// Connection is a user in a followers or following list
+type Connection struct {
+	ID         int64     `json:"id"`
+	UserName   string    `json:"username"`
+	FollowedAt time.Time `json:"followed_at"`
+}
+
+type ConnectionPage struct {
+	Users      []Connection
+	NextCursor string
+}
+
+// Relationship is how the viewer and another user are connected
+type Relationship struct {
+	IsFollowing bool `json:"is_following"` // the viewer follows the user
+	FollowsYou  bool `json:"follows_you"`  // the user follows the viewer
+}
+
+// SuggestedUser is someone the people you follow also follow
+type SuggestedUser struct {
+	ID       int64  `json:"id"`
+	UserName string `json:"username"`
+	Mutuals  int    `json:"mutuals"` // how many of the people you follow follow them
+}
+
+// ListFollowers pages through the users following userID, newest follow first,
+// without the users the viewer blocked or was blocked by
+func (s *FollowStore) ListFollowers(ctx context.Context, viewerID, userID int64, q PaginatedQuery) (*ConnectionPage, error) {
 	query := `
-		DELETE FROM followers
-		WHERE user_id = $1 AND follower_id = $2
+		SELECT u.id, u.username, f.created_at
+		FROM followers AS f
+		JOIN users AS u ON u.id = f.follower_id
+		WHERE
+			f.user_id = $2 AND
+			u.id NOT IN (` + blockedUsersOf + `) AND
+			($3::timestamptz IS NULL OR (f.created_at, f.follower_id) < ($3, $4))
+		ORDER BY f.created_at DESC, f.follower_id DESC
+		LIMIT $5
 	`
+	return s.listConnections(ctx, query, viewerID, userID, q)
+}
+
+// ListFollowing pages through the users userID follows, newest follow first,
+// without the users the viewer blocked or was blocked by
+func (s *FollowStore) ListFollowing(ctx context.Context, viewerID, userID int64, q PaginatedQuery) (*ConnectionPage, error) {
+	query := `
+		SELECT u.id, u.username, f.created_at
+		FROM followers AS f
+		JOIN users AS u ON u.id = f.user_id
+		WHERE
+			f.follower_id = $2 AND
+			u.id NOT IN (` + blockedUsersOf + `) AND
+			($3::timestamptz IS NULL OR (f.created_at, f.user_id) < ($3, $4))
+		ORDER BY f.created_at DESC, f.user_id DESC
+		LIMIT $5
+	`
+	return s.listConnections(ctx, query, viewerID, userID, q)
+}
+
+func (s *FollowStore) listConnections(ctx context.Context, query string, viewerID, userID int64, q PaginatedQuery) (*ConnectionPage, error) {
+	var cursorAt any
+	var cursorID int64
+	if q.Cursor != nil {
+		cursorAt, cursorID = q.Cursor.CreatedAt, q.Cursor.ID
+	}
 
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
 	defer cancel()
 
-	_, err := s.db.ExecContext(ctx, query, userID, followerID)
+	rows, err := s.db.QueryContext(ctx, query, viewerID, userID, cursorAt, cursorID, q.Limit+1)
 	if err != nil {
-		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
-			return Errconflict
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []Connection{}
+	for rows.Next() {
+		var c Connection
+		if err := rows.Scan(&c.ID, &c.UserName, &c.FollowedAt); err != nil {
+			return nil, err
 		}
-		return err
+		users = append(users, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
 	}
 
-	return nil
+	page := &ConnectionPage{Users: users}
+	if len(users) > q.Limit {
+		page.Users = users[:q.Limit]
+		last := page.Users[q.Limit-1]
+		page.NextCursor = Cursor{CreatedAt: last.FollowedAt, ID: last.ID, Sort: "desc"}.Encode()
+	}
+
+	return page, nil
+}
+
+// Counts returns how many users follow userID and how many userID follows
+func (s *FollowStore) Counts(ctx context.Context, userID int64) (followers int, following int, err error) {
+	query := `
+		SELECT
+			(SELECT COUNT(*) FROM followers WHERE user_id = $1),
+			(SELECT COUNT(*) FROM followers WHERE follower_id = $1)
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	err = s.db.QueryRowContext(ctx, query, userID).Scan(&followers, &following)
+	return followers, following, err
+}
+
+func (s *FollowStore) Relationship(ctx context.Context, viewerID, userID int64) (*Relationship, error) {
+	query := `
+		SELECT
+			EXISTS (SELECT 1 FROM followers WHERE user_id = $2 AND follower_id = $1),
+			EXISTS (SELECT 1 FROM followers WHERE user_id = $1 AND follower_id = $2)
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rel := &Relationship{}
+	if err := s.db.QueryRowContext(ctx, query, viewerID, userID).Scan(&rel.IsFollowing, &rel.FollowsYou); err != nil {
+		return nil, err
+	}
+
+	return rel, nil
+}
+
+// Suggestions returns friends of friends the user does not follow yet and has no
+// block with, the ones most of their followings follow come first
+func (s *FollowStore) Suggestions(ctx context.Context, userID int64, limit int) ([]SuggestedUser, error) {
+	query := `
+		SELECT u.id, u.username, COUNT(*) AS mutuals
+		FROM followers AS mine
+		JOIN followers AS theirs ON theirs.follower_id = mine.user_id
+		JOIN users AS u ON u.id = theirs.user_id
+		WHERE
+			mine.follower_id = $1 AND
+			theirs.user_id <> $1 AND
+			u.is_active AND
+			u.id NOT IN (` + blockedUsersOf + `) AND
+			NOT EXISTS (SELECT 1 FROM followers AS f WHERE f.follower_id = $1 AND f.user_id = theirs.user_id)
+		GROUP BY u.id, u.username
+		ORDER BY mutuals DESC, u.id DESC
+		LIMIT $2
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []SuggestedUser{}
+	for rows.Next() {
+		var u SuggestedUser
+		if err := rows.Scan(&u.ID, &u.UserName, &u.Mutuals); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+
+	return users, rows.Err()
+}
+
+// FanoutIDs returns the followers of authorID that should hear about their new posts,
+// followers who muted the author are left out
+func (s *FollowStore) FanoutIDs(ctx context.Context, authorID int64) ([]int64, error) {
+	query := `
+		SELECT follower_id FROM followers
+		WHERE
+			user_id = $1 AND
+			follower_id NOT IN (SELECT muter_id FROM mutes WHERE muted_id = $1)
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, authorID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
 }
@@ -0,0 +1,80 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/lib/pq"
+	"github.com/sirUnchained/udemy-backend-course/internal/notifications"
+)
+
+// insertMentions records the mentions of a post (commentID nil) or of a comment and
+// notifies the mentioned users, except the ones in alreadyNotified.
+// usernames that don't exist, are inactive, are the author or blocked the author are skipped.
+// usernames are unique regardless of case, so they come in lower cased and match lower(username).
+func insertMentions(ctx context.Context, tx *sql.Tx, authorID, postID int64, commentID *int64, usernames []string, alreadyNotified ...int64) error {
+	if len(usernames) == 0 {
+		return nil
+	}
+
+	query := `
+		INSERT INTO mentions (user_id, author_id, post_id, comment_id)
+		SELECT u.id, $1, $2, $3
+		FROM users AS u
+		WHERE
+			lower(u.username) = ANY($4) AND
+			u.id <> $1 AND
+			u.is_active AND
+			u.id NOT IN (` + blockedUsersOf + `)
+		ON CONFLICT DO NOTHING
+		RETURNING user_id
+	`
+
+	rows, err := tx.QueryContext(ctx, query, authorID, postID, commentID, pq.Array(usernames))
+	if err != nil {
+		return err
+	}
+
+	var mentioned []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		mentioned = append(mentioned, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	// the rows have to be closed before the tx can run anything else
+	for _, userID := range mentioned {
+		if contains(alreadyNotified, userID) {
+			continue
+		}
+
+		err := notifications.Insert(ctx, tx, &notifications.Notification{
+			UserID:    userID,
+			ActorID:   authorID,
+			Kind:      notifications.KindMention,
+			PostID:    &postID,
+			CommentID: commentID,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func contains(ids []int64, id int64) bool {
+	for _, existing := range ids {
+		if existing == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,310 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+type MessageStore struct {
+	db *sql.DB
+}
+
+type Message struct {
+	ID             int64      `json:"id"`
+	ConversationID int64      `json:"conversation_id"`
+	SenderID       int64      `json:"sender_id"`
+	RecipientID    int64      `json:"recipient_id"`
+	Content        string     `json:"content"`
+	ReadAt         *time.Time `json:"read_at"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+// Conversation is seen from one of its two users, With is the other one
+type Conversation struct {
+	ID            int64            `json:"id"`
+	With          ConversationUser `json:"with"`
+	LastMessage   *Message         `json:"last_message,omitempty"`
+	UnreadCount   int              `json:"unread_count"`
+	LastMessageAt time.Time        `json:"last_message_at"`
+	CreatedAt     time.Time        `json:"created_at"`
+}
+
+type ConversationUser struct {
+	ID       int64  `json:"id"`
+	UserName string `json:"username"`
+}
+
+type ConversationPage struct {
+	Conversations []Conversation
+	NextCursor    string
+}
+
+type MessagePage struct {
+	Messages   []Message
+	NextCursor string
+}
+
+// ReadReceipt says that ReaderID read every message of SenderID in the conversation up to MessageID
+type ReadReceipt struct {
+	ConversationID int64     `json:"conversation_id"`
+	ReaderID       int64     `json:"reader_id"`
+	SenderID       int64     `json:"-"`
+	MessageID      int64     `json:"message_id"`
+	ReadAt         time.Time `json:"read_at"`
+}
+
+// Send stores a message from senderID to recipientID, starting their conversation if needed.
+// it returns ErrBlocked when either user blocked the other and ErrNotFound when the recipient doesn't exist.
+func (s *MessageStore) Send(ctx context.Context, senderID, recipientID int64, content string) (*Message, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	message := &Message{SenderID: senderID, RecipientID: recipientID, Content: content}
+	err := withTeransaction(s.db, ctx, func(tx *sql.Tx) error {
+		var blocked bool
+		if err := tx.QueryRowContext(ctx, `SELECT `+blockedEitherWay, senderID, recipientID).Scan(&blocked); err != nil {
+			return err
+		}
+		if blocked {
+			return ErrBlocked
+		}
+
+		query := `
+			INSERT INTO conversations (user_a, user_b)
+			VALUES (LEAST($1::bigint, $2::bigint), GREATEST($1::bigint, $2::bigint))
+			ON CONFLICT (user_a, user_b) DO UPDATE SET last_message_at = NOW()
+			RETURNING id
+		`
+		if err := tx.QueryRowContext(ctx, query, senderID, recipientID).Scan(&message.ConversationID); err != nil {
+			if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23503" {
+				return ErrNotFound
+			}
+			return err
+		}
+
+		query = `
+			INSERT INTO messages (conversation_id, sender_id, content)
+			VALUES ($1, $2, $3)
+			RETURNING id, created_at
+		`
+		return tx.QueryRowContext(ctx, query, message.ConversationID, senderID, content).Scan(&message.ID, &message.CreatedAt)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return message, nil
+}
+
+// ListConversations pages through the conversations of userID, the most recently active first
+func (s *MessageStore) ListConversations(ctx context.Context, userID int64, q PaginatedQuery) (*ConversationPage, error) {
+	query := `
+		SELECT
+			c.id, u.id, u.username, c.last_message_at, c.created_at,
+			m.id, m.sender_id, m.content, m.read_at, m.created_at,
+			(
+				SELECT COUNT(*) FROM messages
+				WHERE conversation_id = c.id AND sender_id <> $1 AND read_at IS NULL
+			)
+		FROM conversations AS c
+		JOIN users AS u ON u.id = CASE WHEN c.user_a = $1 THEN c.user_b ELSE c.user_a END
+		JOIN LATERAL (
+			SELECT id, sender_id, content, read_at, created_at FROM messages
+			WHERE conversation_id = c.id
+			ORDER BY id DESC
+			LIMIT 1
+		) AS m ON true
+		WHERE
+			(c.user_a = $1 OR c.user_b = $1) AND
+			($2::timestamptz IS NULL OR (c.last_message_at, c.id) < ($2, $3))
+		ORDER BY c.last_message_at DESC, c.id DESC
+		LIMIT $4
+	`
+
+	var cursorAt any
+	var cursorID int64
+	if q.Cursor != nil {
+		cursorAt, cursorID = q.Cursor.CreatedAt, q.Cursor.ID
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, userID, cursorAt, cursorID, q.Limit+1)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	conversations := []Conversation{}
+	for rows.Next() {
+		var c Conversation
+		m := &Message{}
+		err := rows.Scan(
+			&c.ID,
+			&c.With.ID,
+			&c.With.UserName,
+			&c.LastMessageAt,
+			&c.CreatedAt,
+			&m.ID,
+			&m.SenderID,
+			&m.Content,
+			&m.ReadAt,
+			&m.CreatedAt,
+			&c.UnreadCount,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		m.ConversationID = c.ID
+		m.RecipientID = userID
+		if m.SenderID == userID {
+			m.RecipientID = c.With.ID
+		}
+		c.LastMessage = m
+
+		conversations = append(conversations, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	page := &ConversationPage{Conversations: conversations}
+	if len(conversations) > q.Limit {
+		page.Conversations = conversations[:q.Limit]
+		last := page.Conversations[q.Limit-1]
+		page.NextCursor = Cursor{CreatedAt: last.LastMessageAt, ID: last.ID, Sort: "desc"}.Encode()
+	}
+
+	return page, nil
+}
+
+// GetConversation returns ErrNotFound when userID is not part of the conversation
+func (s *MessageStore) GetConversation(ctx context.Context, conversationID, userID int64) (*Conversation, error) {
+	query := `
+		SELECT c.id, u.id, u.username, c.last_message_at, c.created_at
+		FROM conversations AS c
+		JOIN users AS u ON u.id = CASE WHEN c.user_a = $2 THEN c.user_b ELSE c.user_a END
+		WHERE c.id = $1 AND (c.user_a = $2 OR c.user_b = $2)
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	c := &Conversation{}
+	err := s.db.QueryRowContext(ctx, query, conversationID, userID).Scan(
+		&c.ID,
+		&c.With.ID,
+		&c.With.UserName,
+		&c.LastMessageAt,
+		&c.CreatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// ListMessages pages back through the history of a conversation, newest message first
+func (s *MessageStore) ListMessages(ctx context.Context, conversationID int64, q PaginatedQuery) (*MessagePage, error) {
+	query := `
+		SELECT
+			m.id, m.sender_id,
+			CASE WHEN m.sender_id = c.user_a THEN c.user_b ELSE c.user_a END,
+			m.content, m.read_at, m.created_at
+		FROM messages AS m
+		JOIN conversations AS c ON c.id = m.conversation_id
+		WHERE
+			m.conversation_id = $1 AND
+			($2::bigint = 0 OR m.id < $2)
+		ORDER BY m.id DESC
+		LIMIT $3
+	`
+
+	// messages ids only grow, so the id alone is enough to page
+	var cursorID int64
+	if q.Cursor != nil {
+		cursorID = q.Cursor.ID
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, conversationID, cursorID, q.Limit+1)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := []Message{}
+	for rows.Next() {
+		m := Message{ConversationID: conversationID}
+		if err := rows.Scan(&m.ID, &m.SenderID, &m.RecipientID, &m.Content, &m.ReadAt, &m.CreatedAt); err != nil {
+			return nil, err
+		}
+		messages = append(messages, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	page := &MessagePage{Messages: messages}
+	if len(messages) > q.Limit {
+		page.Messages = messages[:q.Limit]
+		last := page.Messages[q.Limit-1]
+		page.NextCursor = Cursor{CreatedAt: last.CreatedAt, ID: last.ID, Sort: "desc"}.Encode()
+	}
+
+	return page, nil
+}
+
+// MarkRead marks the messages readerID received in the conversation up to upToID as read.
+// the receipt has MessageID 0 when there was nothing new to mark, and ErrNotFound
+// is returned when readerID is not part of the conversation.
+func (s *MessageStore) MarkRead(ctx context.Context, conversationID, readerID, upToID int64) (*ReadReceipt, error) {
+	query := `
+		WITH conversation AS (
+			SELECT id, CASE WHEN user_a = $2 THEN user_b ELSE user_a END AS other_id
+			FROM conversations
+			WHERE id = $1 AND (user_a = $2 OR user_b = $2)
+		), updated AS (
+			UPDATE messages SET read_at = NOW()
+			WHERE
+				conversation_id = (SELECT id FROM conversation) AND
+				sender_id <> $2 AND
+				id <= $3 AND
+				read_at IS NULL
+			RETURNING id
+		)
+		SELECT
+			(SELECT other_id FROM conversation),
+			(SELECT COALESCE(MAX(id), 0) FROM updated),
+			NOW()
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var senderID sql.NullInt64
+	receipt := &ReadReceipt{ConversationID: conversationID, ReaderID: readerID}
+	if err := s.db.QueryRowContext(ctx, query, conversationID, readerID, upToID).Scan(&senderID, &receipt.MessageID, &receipt.ReadAt); err != nil {
+		return nil, err
+	}
+	if !senderID.Valid {
+		return nil, ErrNotFound
+	}
+	receipt.SenderID = senderID.Int64
+
+	return receipt, nil
+}
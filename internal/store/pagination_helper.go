@@ -1,41 +1,105 @@
 package store
 
 import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"net/http"
 	"strconv"
 	"strings"
 	"time"
 )
 
+var ErrInvalidCursor = errors.New("invalid cursor")
+
 type PaginatedFeedQuery struct {
 	Limit  int       `json:"limit" validate:"gte=1,lte=20"`
-	Offset int       `json:"offset" validate:"gte=0"`
 	Sort   string    `json:"sort" validate:"oneof=asc desc"`
+	Mode   string    `json:"mode" validate:"oneof=latest top"`
 	Tags   []string  `json:"tags" validate:"max=5"`
 	Search string    `json:"search" validate:"max=100"`
 	Since  time.Time `json:"since"`
 	Until  time.Time `json:"until"`
+	Cursor *Cursor   `json:"-"`
 }
 
-func (fq PaginatedFeedQuery) Parse(r *http.Request) (PaginatedFeedQuery, error) {
+// Cursor points at the last (or first, when Prev is set) row of a page,
+// clients only ever see it as an opaque string
+type Cursor struct {
+	CreatedAt time.Time `json:"c"`
+	ID        int64     `json:"i"`
+	Sort      string    `json:"s"`
+	Prev      bool      `json:"p,omitempty"` // walk back to the page before this row
+
+	// ranked feeds page over (score, id), scored as of the time the first page was made
+	Mode  string    `json:"m,omitempty"`
+	Score float64   `json:"sc,omitempty"`
+	AsOf  time.Time `json:"a,omitzero"`
+}
+
+func (c Cursor) Encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func DecodeCursor(str string) (*Cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(str)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	c := &Cursor{}
+	if err := json.Unmarshal(data, c); err != nil || c.ID <= 0 || (c.Sort != "asc" && c.Sort != "desc") {
+		return nil, ErrInvalidCursor
+	}
+
+	if c.Mode == FeedModeTop && (c.AsOf.IsZero() || c.Prev) {
+		return nil, ErrInvalidCursor
+	}
+
+	return c, nil
+}
+
+// PaginatedQuery is the plain limit + cursor query of lists that have no filters
+type PaginatedQuery struct {
+	Limit  int     `json:"limit" validate:"gte=1,lte=50"`
+	Cursor *Cursor `json:"-"`
+}
+
+func (q PaginatedQuery) Parse(r *http.Request) (PaginatedQuery, error) {
 	qs := r.URL.Query()
 
 	limit := qs.Get("limit")
 	if limit != "" {
 		l, err := strconv.Atoi(limit)
 		if err != nil {
-			return fq, err
+			return q, err
 		}
-		fq.Limit = l
+		q.Limit = l
+	}
+
+	cursor := qs.Get("cursor")
+	if cursor != "" {
+		c, err := DecodeCursor(cursor)
+		if err != nil {
+			return q, err
+		}
+		q.Cursor = c
 	}
 
-	offset := qs.Get("offset")
-	if offset != "" {
-		off, err := strconv.Atoi(offset)
+	return q, nil
+}
+
+func (fq PaginatedFeedQuery) Parse(r *http.Request) (PaginatedFeedQuery, error) {
+	qs := r.URL.Query()
+
+	limit := qs.Get("limit")
+	if limit != "" {
+		l, err := strconv.Atoi(limit)
 		if err != nil {
 			return fq, err
 		}
-		fq.Offset = off
+		fq.Limit = l
 	}
 
 	sort := qs.Get("sort")
@@ -43,36 +107,69 @@ func (fq PaginatedFeedQuery) Parse(r *http.Request) (PaginatedFeedQuery, error)
 		fq.Sort = sort
 	}
 
+	mode := qs.Get("mode")
+	if mode != "" {
+		fq.Mode = mode
+	}
+
 	tags := qs.Get("tags")
-	if tags == "" {
+	if tags != "" {
 		fq.Tags = strings.Split(tags, ",")
 	}
 
 	search := qs.Get("search")
-	if search == "" {
+	if search != "" {
 		fq.Search = search
 	}
 
 	since := qs.Get("since")
-	if since == "" {
-		fq.Since = parssTime(since)
+	if since != "" {
+		t, err := parseTime(since)
+		if err != nil {
+			return fq, err
+		}
+		fq.Since = t
 	}
 
 	until := qs.Get("until")
-	if until == "" {
-		fq.Until = parssTime(until)
+	if until != "" {
+		t, err := parseTime(until)
+		if err != nil {
+			return fq, err
+		}
+		fq.Until = t
+	}
+
+	cursor := qs.Get("cursor")
+	if cursor != "" {
+		c, err := DecodeCursor(cursor)
+		if err != nil {
+			return fq, err
+		}
+		// the cursor only makes sense in the order it was made for
+		fq.Cursor = c
+		fq.Sort = c.Sort
+		fq.Mode = FeedModeLatest
+		if c.Mode != "" {
+			fq.Mode = c.Mode
+		}
 	}
 
 	return fq, nil
 }
 
-func parssTime(str string) time.Time {
-	if str == "" {
-		return time.Time{}
+// parseTime accepts both "2006-01-02 15:04:05" and rfc3339
+func parseTime(str string) (time.Time, error) {
+	if t, err := time.Parse(time.DateTime, str); err == nil {
+		return t, nil
 	}
-	t, err := time.Parse(time.DateTime, str)
-	if err != nil {
-		return time.Time{}
+	return time.Parse(time.RFC3339, str)
+}
+
+// nullTime turns the zero time into NULL so queries can skip the filter
+func nullTime(t time.Time) any {
+	if t.IsZero() {
+		return nil
 	}
 	return t
 }
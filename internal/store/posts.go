@@ -11,7 +11,8 @@ import (
 
 // this is post store (something like post database?)
 type PostStore struct {
-	db *sql.DB
+	db      *sql.DB
+	blobURL URLFunc
 }
 
 // structure of post entity
@@ -25,12 +26,16 @@ type Post struct {
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 	Comments  []Comment `json:"comments"`
-	User      User      `json:"user"`
+	// always loaded with the post, unlike comments
+	Attachments []Attachment `json:"attachments"`
+	User        Author       `json:"user"`
+	Mentions    []string     `json:"-"` // usernames to record as mentioned when the post is created
 }
 
 type PostWithMetadata struct {
 	Post
 	CommentsCount int `json:"comments_count"`
+	ReactionSummary
 }
 
 // CRUD users
@@ -40,26 +45,32 @@ func (s *PostStore) Create(ctx context.Context, post *Post) error {
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
 	defer cancel()
 
-	err := s.db.QueryRowContext(ctx, query,
-		post.Content,
-		post.Title,
-		post.UserID,
-		pq.Array(post.Tags),
-	).Scan(
-		&post.ID,
-		&post.CreatedAt,
-		&post.UpdatedAt,
-	)
-	if err != nil {
-		return err
-	}
+	return withTeransaction(s.db, ctx, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, query,
+			post.Content,
+			post.Title,
+			post.UserID,
+			pq.Array(post.Tags),
+		).Scan(
+			&post.ID,
+			&post.CreatedAt,
+			&post.UpdatedAt,
+		)
+		if err != nil {
+			return err
+		}
 
-	return nil
+		return insertMentions(ctx, tx, post.UserID, post.ID, nil, post.Mentions)
+	})
 }
 
 func (s *PostStore) GetById(ctx context.Context, id int64) (*Post, error) {
 	var post Post
-	query := `SELECT pg_sleep(5) id, content, title, user_id, tags, version, created_at, updated_at FROM posts WHERE id = $1`
+	query := `
+		SELECT p.id, p.content, p.title, p.user_id, p.tags, p.version, p.created_at, p.updated_at, ` + attachmentColumns + `
+		FROM posts AS p
+		WHERE p.id = $1
+	`
 
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
 	defer cancel()
@@ -73,6 +84,7 @@ func (s *PostStore) GetById(ctx context.Context, id int64) (*Post, error) {
 		&post.Version,
 		&post.CreatedAt,
 		&post.UpdatedAt,
+		scanAttachments(&post.Attachments, s.blobURL),
 	)
 	if err != nil {
 		switch {
@@ -86,57 +98,6 @@ func (s *PostStore) GetById(ctx context.Context, id int64) (*Post, error) {
 	return &post, nil
 }
 
-func (s *PostStore) GetUserFeed(ctx context.Context, id int64, fq PaginatedFeedQuery) ([]PostWithMetadata, error) {
-	query := `
-	SELECT p.id, p.user_id, p.title, p.content, p.created_at, p.version, p.tags, 
-		u.username, COUNT(*) AS comments_count
-	FROM posts AS p
-	LEFT JOIN comments AS c on c.post_id = p.id
-	LEFT JOIN users AS u ON p.user_id = u.id
-	JOIN followers AS f ON f.follower_id = p.user_id OR p.user_id = $1
-	WHERE 
-		f.user_id = $1 AND
-		(p.title ILIKE '%' || $2 || '%' OR p.content ILIKE '%' || $2 || '%') AND
-		(p.tags @> $3 OR $3 = '{}')
-	GROUP BY p.id, u.username
-	ORDER BY p.created_at ` + fq.Sort + `
-	LIMIT $4 OFFSET $5;
-	`
-
-	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
-	defer cancel()
-
-	rows, err := s.db.QueryContext(ctx, query, id, fq.Search, pq.Array(fq.Tags), fq.Limit, fq.Offset)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var feed []PostWithMetadata
-	for rows.Next() {
-		var p PostWithMetadata
-
-		err := rows.Scan(
-			&p.ID,
-			&p.UserID,
-			&p.Title,
-			&p.Content,
-			&p.CreatedAt,
-			&p.Version,
-			pq.Array(&p.Tags),
-			&p.User.UserName,
-			&p.CommentsCount,
-		)
-		if err != nil {
-			return nil, err
-		}
-
-		feed = append(feed, p)
-	}
-
-	return feed, nil
-}
-
 func (s *PostStore) DeleteById(ctx context.Context, id int64) error {
 	query := `DELETE FROM posts WHERE id = $1`
 
@@ -190,3 +151,45 @@ func (s *PostStore) Update(ctx context.Context, post *Post) error {
 
 	return nil
 }
+
+// CreateBatch inserts all posts with a single query, used by the seeder
+func (s *PostStore) CreateBatch(ctx context.Context, tx *sql.Tx, posts []*Post) error {
+	if len(posts) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	ids, err := reserveIDs(ctx, tx, "posts", len(posts))
+	if err != nil {
+		return err
+	}
+
+	query := `INSERT INTO posts (id, content, title, user_id, tags) VALUES ` + placeholders(len(posts), 5) + ` RETURNING id, created_at, updated_at`
+
+	byID := make(map[int64]*Post, len(posts))
+	args := make([]any, 0, len(posts)*5)
+	for i, p := range posts {
+		p.ID = ids[i]
+		byID[p.ID] = p
+		args = append(args, p.ID, p.Content, p.Title, p.UserID, pq.Array(p.Tags))
+	}
+
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		var createdAt, updatedAt time.Time
+		if err := rows.Scan(&id, &createdAt, &updatedAt); err != nil {
+			return err
+		}
+		byID[id].CreatedAt, byID[id].UpdatedAt = createdAt, updatedAt
+	}
+
+	return rows.Err()
+}
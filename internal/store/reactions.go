@@ -0,0 +1,158 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+
+	"github.com/lib/pq"
+)
+
+// reaction kinds, the reactions table has a check constraint with the same list
+const (
+	ReactionLike  = "like"
+	ReactionLove  = "love"
+	ReactionLaugh = "laugh"
+	ReactionWow   = "wow"
+	ReactionSad   = "sad"
+	ReactionAngry = "angry"
+)
+
+var ReactionKinds = []string{ReactionLike, ReactionLove, ReactionLaugh, ReactionWow, ReactionSad, ReactionAngry}
+
+var ErrInvalidReaction = errors.New("unknown reaction kind")
+
+func IsReactionKind(kind string) bool {
+	for _, k := range ReactionKinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// reactions database
+type ReactionStore struct {
+	db *sql.DB
+}
+
+// ReactionSummary is what a viewer sees about the reactions of a post
+type ReactionSummary struct {
+	Reactions       map[string]int `json:"reactions"`
+	ViewerReacted   bool           `json:"viewer_reacted"`
+	ViewerReactions []string       `json:"viewer_reactions"`
+}
+
+// reactionSummaryColumns reads a ReactionSummary for the post p, $1 must be the viewer id
+const reactionSummaryColumns = `
+	(SELECT COALESCE(jsonb_object_agg(rc.kind, rc.count) FILTER (WHERE rc.count > 0), '{}')
+		FROM post_reaction_counts AS rc WHERE rc.post_id = p.id) AS reactions,
+	ARRAY(SELECT r.kind FROM reactions AS r WHERE r.post_id = p.id AND r.user_id = $1 ORDER BY r.kind) AS viewer_reactions`
+
+// reactionSummaryScanner returns the scan targets of reactionSummaryColumns and a
+// function that fills summary once the row is scanned
+func reactionSummaryScanner(summary *ReactionSummary) ([]any, func() error) {
+	var counts []byte
+	var viewer pq.StringArray
+
+	return []any{&counts, &viewer}, func() error {
+		summary.Reactions = map[string]int{}
+		if err := json.Unmarshal(counts, &summary.Reactions); err != nil {
+			return err
+		}
+		summary.ViewerReactions = []string(viewer)
+		if summary.ViewerReactions == nil {
+			summary.ViewerReactions = []string{}
+		}
+		summary.ViewerReacted = len(summary.ViewerReactions) > 0
+		return nil
+	}
+}
+
+// Add reacts to the post, reacting twice with the same kind does nothing
+func (s *ReactionStore) Add(ctx context.Context, postID, userID int64, kind string) error {
+	if !IsReactionKind(kind) {
+		return ErrInvalidReaction
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return withTeransaction(s.db, ctx, func(tx *sql.Tx) error {
+		query := `
+			INSERT INTO reactions (post_id, user_id, kind) VALUES ($1, $2, $3)
+			ON CONFLICT DO NOTHING
+		`
+		result, err := tx.ExecContext(ctx, query, postID, userID, kind)
+		if err != nil {
+			// the post was deleted under us
+			if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23503" {
+				return ErrNotFound
+			}
+			return err
+		}
+
+		if rows, err := result.RowsAffected(); err != nil || rows == 0 {
+			return err
+		}
+
+		query = `
+			INSERT INTO post_reaction_counts (post_id, kind, count) VALUES ($1, $2, 1)
+			ON CONFLICT (post_id, kind) DO UPDATE SET count = post_reaction_counts.count + 1
+		`
+		_, err = tx.ExecContext(ctx, query, postID, kind)
+		return err
+	})
+}
+
+// Remove takes the reaction back, removing one that doesn't exist does nothing
+func (s *ReactionStore) Remove(ctx context.Context, postID, userID int64, kind string) error {
+	if !IsReactionKind(kind) {
+		return ErrInvalidReaction
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return withTeransaction(s.db, ctx, func(tx *sql.Tx) error {
+		query := `DELETE FROM reactions WHERE post_id = $1 AND user_id = $2 AND kind = $3`
+		result, err := tx.ExecContext(ctx, query, postID, userID, kind)
+		if err != nil {
+			return err
+		}
+
+		if rows, err := result.RowsAffected(); err != nil || rows == 0 {
+			return err
+		}
+
+		query = `UPDATE post_reaction_counts SET count = count - 1 WHERE post_id = $1 AND kind = $2`
+		_, err = tx.ExecContext(ctx, query, postID, kind)
+		return err
+	})
+}
+
+// GetSummary returns the reaction counts of the post and what the viewer reacted with
+func (s *ReactionStore) GetSummary(ctx context.Context, postID, viewerID int64) (*ReactionSummary, error) {
+	query := `SELECT ` + reactionSummaryColumns + ` FROM posts AS p WHERE p.id = $2`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	summary := &ReactionSummary{}
+	dest, fill := reactionSummaryScanner(summary)
+	if err := s.db.QueryRowContext(ctx, query, viewerID, postID).Scan(dest...); err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	if err := fill(); err != nil {
+		return nil, err
+	}
+
+	return summary, nil
+}
@@ -0,0 +1,301 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"html"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// search orders
+const (
+	SearchByRelevance = "relevance"
+	SearchByDate      = "date"
+)
+
+// user search modes
+const (
+	UserSearchFuzzy  = "fuzzy"
+	UserSearchPrefix = "prefix"
+)
+
+// ts_headline marks the matches with these, they are removed from the text first so
+// they can't come from a post. highlight turns them into <mark> after escaping the rest.
+const (
+	highlightStart = "\x02"
+	highlightStop  = "\x03"
+)
+
+var (
+	headlineTitleOptions = `HighlightAll=true, StartSel="` + highlightStart + `", StopSel="` + highlightStop + `"`
+	headlineOptions      = `StartSel="` + highlightStart + `", StopSel="` + highlightStop + `", MaxWords=35, MinWords=15, MaxFragments=2`
+
+	highlightReplacer = strings.NewReplacer(highlightStart, "<mark>", highlightStop, "</mark>")
+)
+
+// highlight escapes a ts_headline result for html and wraps the matches in <mark>
+func highlight(headline string) string {
+	return highlightReplacer.Replace(html.EscapeString(headline))
+}
+
+// search database
+type SearchStore struct {
+	db *sql.DB
+}
+
+type PostSearchQuery struct {
+	Query    string  `json:"q" validate:"required,max=200"`
+	AuthorID int64   `json:"author_id" validate:"gte=0"`
+	Tag      string  `json:"tag" validate:"max=100"`
+	Order    string  `json:"order" validate:"oneof=relevance date"`
+	Limit    int     `json:"limit" validate:"gte=1,lte=50"`
+	Cursor   *Cursor `json:"-"`
+}
+
+func (q PostSearchQuery) Parse(r *http.Request) (PostSearchQuery, error) {
+	qs := r.URL.Query()
+
+	q.Query = qs.Get("q")
+	q.Tag = qs.Get("tag")
+
+	author := qs.Get("author_id")
+	if author != "" {
+		id, err := strconv.ParseInt(author, 10, 64)
+		if err != nil {
+			return q, err
+		}
+		q.AuthorID = id
+	}
+
+	order := qs.Get("order")
+	if order != "" {
+		q.Order = order
+	}
+
+	limit := qs.Get("limit")
+	if limit != "" {
+		l, err := strconv.Atoi(limit)
+		if err != nil {
+			return q, err
+		}
+		q.Limit = l
+	}
+
+	cursor := qs.Get("cursor")
+	if cursor != "" {
+		c, err := DecodeCursor(cursor)
+		if err != nil {
+			return q, err
+		}
+		if c.Mode != SearchByRelevance && c.Mode != SearchByDate {
+			return q, ErrInvalidCursor
+		}
+		q.Cursor = c
+		q.Order = c.Mode
+	}
+
+	return q, nil
+}
+
+// PostSearchResult is a matching post, the highlights are escaped html with <mark> around the matches
+type PostSearchResult struct {
+	ID             int64     `json:"id"`
+	UserID         int64     `json:"user_id"`
+	UserName       string    `json:"username"`
+	Title          string    `json:"title"`
+	TitleHighlight string    `json:"title_highlight"`
+	Snippet        string    `json:"snippet"`
+	Tags           []string  `json:"tags"`
+	CreatedAt      time.Time `json:"created_at"`
+	Rank           float64   `json:"rank"`
+}
+
+type PostSearchPage struct {
+	Posts      []PostSearchResult
+	NextCursor string
+}
+
+// SearchPosts runs a websearch style query ("quoted phrases", -excluded, or) over
+// every post the viewer is allowed to see
+func (s *SearchStore) SearchPosts(ctx context.Context, viewerID int64, q PostSearchQuery) (*PostSearchPage, error) {
+	const rank = `ts_rank(p.search_vector, tq.query)::float8`
+
+	// picked from a fixed set, never from user input
+	order := rank + ` DESC, p.id DESC`
+	if q.Order == SearchByDate {
+		order = `p.created_at DESC, p.id DESC`
+	}
+
+	query := `
+	WITH tq AS (SELECT websearch_to_tsquery('english', $2) AS query)
+	SELECT p.id, p.user_id, u.username, p.title,
+		ts_headline('english', translate(p.title, $9, ''), tq.query, $10),
+		ts_headline('english', translate(p.content, $9, ''), tq.query, $11),
+		p.tags, p.created_at, ` + rank + `
+	FROM posts AS p
+	CROSS JOIN tq
+	JOIN users AS u ON u.id = p.user_id
+	WHERE
+		p.search_vector @@ tq.query AND
+		($3::bigint = 0 OR p.user_id = $3) AND
+		($4::text = '' OR p.tags @> ARRAY[$4::text]) AND
+		p.user_id NOT IN (` + hiddenAuthorsOf + `) AND
+		($5::float8 IS NULL OR (` + rank + `, p.id) < ($5, $7)) AND
+		($6::timestamptz IS NULL OR (p.created_at, p.id) < ($6, $7))
+	ORDER BY ` + order + `
+	LIMIT $8
+	`
+
+	var cursorRank, cursorAt any
+	var cursorID int64
+	if q.Cursor != nil {
+		cursorID = q.Cursor.ID
+		if q.Order == SearchByDate {
+			cursorAt = q.Cursor.CreatedAt
+		} else {
+			cursorRank = q.Cursor.Score
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, viewerID, q.Query, q.AuthorID, q.Tag, cursorRank, cursorAt, cursorID, q.Limit+1,
+		highlightStart+highlightStop, headlineTitleOptions, headlineOptions,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	posts := []PostSearchResult{}
+	for rows.Next() {
+		var p PostSearchResult
+		err := rows.Scan(
+			&p.ID,
+			&p.UserID,
+			&p.UserName,
+			&p.Title,
+			&p.TitleHighlight,
+			&p.Snippet,
+			pq.Array(&p.Tags),
+			&p.CreatedAt,
+			&p.Rank,
+		)
+		if err != nil {
+			return nil, err
+		}
+		p.TitleHighlight = highlight(p.TitleHighlight)
+		p.Snippet = highlight(p.Snippet)
+		posts = append(posts, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	page := &PostSearchPage{Posts: posts}
+	if len(posts) > q.Limit {
+		page.Posts = posts[:q.Limit]
+		last := page.Posts[q.Limit-1]
+		page.NextCursor = Cursor{
+			CreatedAt: last.CreatedAt,
+			ID:        last.ID,
+			Sort:      "desc",
+			Mode:      q.Order,
+			Score:     last.Rank,
+		}.Encode()
+	}
+
+	return page, nil
+}
+
+type UserSearchQuery struct {
+	Query string `json:"q" validate:"required,max=100"`
+	Mode  string `json:"mode" validate:"oneof=fuzzy prefix"`
+	Limit int    `json:"limit" validate:"gte=1,lte=50"`
+}
+
+func (q UserSearchQuery) Parse(r *http.Request) (UserSearchQuery, error) {
+	qs := r.URL.Query()
+
+	q.Query = strings.TrimPrefix(strings.TrimSpace(qs.Get("q")), "@")
+
+	mode := qs.Get("mode")
+	if mode != "" {
+		q.Mode = mode
+	}
+
+	limit := qs.Get("limit")
+	if limit != "" {
+		l, err := strconv.Atoi(limit)
+		if err != nil {
+			return q, err
+		}
+		q.Limit = l
+	}
+
+	return q, nil
+}
+
+type UserSearchResult struct {
+	ID         int64   `json:"id"`
+	UserName   string  `json:"username"`
+	Similarity float64 `json:"similarity"`
+}
+
+// SearchUsers finds active users by username. fuzzy mode ranks by trigram similarity
+// and tolerates typos, prefix mode is the cheap one for @mention autocomplete.
+func (s *SearchStore) SearchUsers(ctx context.Context, viewerID int64, q UserSearchQuery) ([]UserSearchResult, error) {
+	query := `
+	SELECT u.id, u.username, similarity(u.username, $2)::float8 AS sim
+	FROM users AS u
+	WHERE
+		u.is_active AND
+		(u.username % $2 OR u.username ILIKE '%' || $3 || '%') AND
+		u.id NOT IN (` + blockedUsersOf + `)
+	ORDER BY sim DESC, u.username ASC
+	LIMIT $4
+	`
+	if q.Mode == UserSearchPrefix {
+		query = `
+		SELECT u.id, u.username, similarity(u.username, $2)::float8
+		FROM users AS u
+		WHERE
+			u.is_active AND
+			lower(u.username) LIKE lower($3) || '%' AND
+			u.id NOT IN (` + blockedUsersOf + `)
+		ORDER BY length(u.username) ASC, u.username ASC
+		LIMIT $4
+		`
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, viewerID, q.Query, escapeLike(q.Query), q.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []UserSearchResult{}
+	for rows.Next() {
+		var u UserSearchResult
+		if err := rows.Scan(&u.ID, &u.UserName, &u.Similarity); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+
+	return users, rows.Err()
+}
+
+// escapeLike makes % and _ in user input match themselves in a LIKE pattern
+func escapeLike(str string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(str)
+}
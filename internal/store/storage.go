@@ -4,7 +4,11 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
+	"strings"
 	"time"
+
+	"github.com/sirUnchained/udemy-backend-course/internal/notifications"
 )
 
 var (
@@ -12,6 +16,7 @@ var (
 	Errconflict           = errors.New("resource already exists")
 	ErrDuplicatedEmail    = errors.New("email duplicated")
 	ErrDuplicatedUsername = errors.New("username duplicated")
+	ErrBlocked            = errors.New("one of the users blocked the other")
 	QueryTimeoutDuration  = time.Second * 5
 )
 
@@ -20,34 +25,113 @@ type Storage struct {
 	Posts interface {
 		Create(context.Context, *Post) error
 		GetById(context.Context, int64) (*Post, error)
-		GetUserFeed(ctx context.Context, id int64, fq PaginatedFeedQuery) ([]PostWithMetadata, error)
+		GetUserFeed(ctx context.Context, id int64, fq PaginatedFeedQuery) (*FeedPage, error)
 		DeleteById(context.Context, int64) error
 		Update(context.Context, *Post) error
+		CreateBatch(context.Context, *sql.Tx, []*Post) error
 	}
 	Users interface {
 		Create(context.Context, *sql.Tx, *User) error
 		GetById(context.Context, int64) (*User, error)
 		Update(context.Context, *sql.Tx, *User) error
+		UpdateProfile(context.Context, *User) error
 		CreateAndInvite(ctx context.Context, user *User, token string, invitationExp time.Duration) error
-		Activate(context.Context, string) error
+		Activate(context.Context, string) (int64, error)
 		GetByEmail(context.Context, string) (*User, error)
+		UpdatePassword(context.Context, *User) error
+		CreatePasswordReset(ctx context.Context, userID int64, tokenHash string, exp time.Duration) error
+		ResetPassword(ctx context.Context, tokenHash string, user *User) error
+		Delete(context.Context, int64) error
+		CreateBatch(context.Context, *sql.Tx, []*User) error
 	}
 	Comments interface {
 		Create(context.Context, *Comment) error
-		GetCommentsByPostId(context.Context, int64) ([]Comment, error)
+		GetById(context.Context, int64) (*Comment, error)
+		GetCommentsByPostId(ctx context.Context, postID, viewerID int64) ([]Comment, error)
+		ListByPost(ctx context.Context, postID, viewerID int64, q PaginatedQuery) (*CommentPage, error)
+		Update(context.Context, *Comment) error
+		SoftDelete(context.Context, int64) error
+		CreateBatch(context.Context, *sql.Tx, []*Comment) error
 	}
 	Followers interface {
 		Follow(ctx context.Context, followerID int64, userID int64) error
 		UnFollow(ctx context.Context, followerID int64, userID int64) error
+		FollowBatch(context.Context, *sql.Tx, []Follower) error
+		ListFollowers(ctx context.Context, viewerID, userID int64, q PaginatedQuery) (*ConnectionPage, error)
+		ListFollowing(ctx context.Context, viewerID, userID int64, q PaginatedQuery) (*ConnectionPage, error)
+		Counts(ctx context.Context, userID int64) (followers int, following int, err error)
+		Relationship(ctx context.Context, viewerID, userID int64) (*Relationship, error)
+		Suggestions(ctx context.Context, userID int64, limit int) ([]SuggestedUser, error)
+		FanoutIDs(ctx context.Context, authorID int64) ([]int64, error)
+	}
+	Blocks interface {
+		Block(ctx context.Context, blockerID, blockedID int64) error
+		Unblock(ctx context.Context, blockerID, blockedID int64) error
+		Mute(ctx context.Context, muterID, mutedID int64) error
+		Unmute(ctx context.Context, muterID, mutedID int64) error
+		IsBlocked(ctx context.Context, userID, otherID int64) (bool, error)
+	}
+	Search interface {
+		SearchPosts(ctx context.Context, viewerID int64, q PostSearchQuery) (*PostSearchPage, error)
+		SearchUsers(ctx context.Context, viewerID int64, q UserSearchQuery) ([]UserSearchResult, error)
+	}
+	Tags interface {
+		GetPosts(ctx context.Context, viewerID int64, tag string, q PaginatedQuery) (*FeedPage, error)
+		Trending(ctx context.Context, window time.Duration, limit int) ([]TrendingTag, error)
+	}
+	Notifications interface {
+		List(ctx context.Context, userID int64, limit int, cursor string, unreadOnly bool) (*notifications.Page, error)
+		Get(ctx context.Context, id int64) (*notifications.Notification, error)
+		UnreadCount(ctx context.Context, userID int64) (int, error)
+		MarkRead(ctx context.Context, userID int64, ids []int64) (int64, error)
+	}
+	Messages interface {
+		Send(ctx context.Context, senderID, recipientID int64, content string) (*Message, error)
+		ListConversations(ctx context.Context, userID int64, q PaginatedQuery) (*ConversationPage, error)
+		GetConversation(ctx context.Context, conversationID, userID int64) (*Conversation, error)
+		ListMessages(ctx context.Context, conversationID int64, q PaginatedQuery) (*MessagePage, error)
+		MarkRead(ctx context.Context, conversationID, readerID, upToID int64) (*ReadReceipt, error)
+	}
+	Attachments interface {
+		Create(ctx context.Context, postID int64, attachments []*Attachment) error
+		ListByPost(ctx context.Context, postID int64) ([]Attachment, error)
+		Delete(ctx context.Context, postID, id int64) (*Attachment, error)
+	}
+	Reactions interface {
+		Add(ctx context.Context, postID, userID int64, kind string) error
+		Remove(ctx context.Context, postID, userID int64, kind string) error
+		GetSummary(ctx context.Context, postID, viewerID int64) (*ReactionSummary, error)
+	}
+	Roles interface {
+		GetByName(context.Context, string) (*Role, error)
+	}
+	Tokens interface {
+		CreateRefreshToken(context.Context, *RefreshToken) error
+		RotateRefreshToken(ctx context.Context, oldHash string, next *RefreshToken) error
+		RevokeRefreshFamily(ctx context.Context, hash string) error
+		RevokeAllForUser(ctx context.Context, userID int64) error
+		RevokeAccessToken(ctx context.Context, jti string, userID int64, expiresAt time.Time) error
+		IsAccessTokenRevoked(ctx context.Context, jti string) (bool, error)
+		DeleteExpired(context.Context) error
 	}
 }
 
-func NewPostgresStorage(db *sql.DB) Storage {
+// blobURL builds the urls of attachments from their blob keys
+func NewPostgresStorage(db *sql.DB, blobURL URLFunc) Storage {
 	return Storage{
-		Posts:     &PostStore{db: db},
-		Users:     &UserStore{db: db},
-		Comments:  &CommentStore{db: db},
-		Followers: &FollowStore{db: db},
+		Posts:         &PostStore{db: db, blobURL: blobURL},
+		Users:         &UserStore{db: db},
+		Comments:      &CommentStore{db: db},
+		Followers:     &FollowStore{db: db},
+		Blocks:        &BlockStore{db: db},
+		Reactions:     &ReactionStore{db: db},
+		Search:        &SearchStore{db: db},
+		Notifications: notifications.NewStore(db),
+		Tags:          &TagStore{db: db, blobURL: blobURL},
+		Messages:      &MessageStore{db: db},
+		Attachments:   &AttachmentStore{db: db, blobURL: blobURL},
+		Roles:         &RoleStore{db: db},
+		Tokens:        &TokenStore{db: db},
 	}
 }
 func withTeransaction(db *sql.DB, ctx context.Context, fn func(*sql.Tx) error) error {
@@ -63,3 +147,45 @@ func withTeransaction(db *sql.DB, ctx context.Context, fn func(*sql.Tx) error) e
 
 	return tx.Commit()
 }
+
+// reserveIDs takes n ids from the serial id column of table. postgres doesn't promise the rows of
+// a multi row insert come back from RETURNING in the order they were inserted, so the batch inserts
+// send the ids themselves instead of matching the returned rows by position.
+// table is always one of our own table names, never user input.
+func reserveIDs(ctx context.Context, tx *sql.Tx, table string, n int) ([]int64, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT nextval(pg_get_serial_sequence($1, 'id')) FROM generate_series(1, $2)`, table, n)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make([]int64, 0, n)
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+// placeholders builds "($1, $2), ($3, $4)" for a multi row insert
+func placeholders(rows, cols int) string {
+	var b strings.Builder
+	for r := 0; r < rows; r++ {
+		if r > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString("(")
+		for c := 0; c < cols; c++ {
+			if c > 0 {
+				b.WriteString(", ")
+			}
+			fmt.Fprintf(&b, "$%d", r*cols+c+1)
+		}
+		b.WriteString(")")
+	}
+	return b.String()
+}
@@ -0,0 +1,92 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// tags database, tags live in posts.tags so there is no table of its own
+type TagStore struct {
+	db      *sql.DB
+	blobURL URLFunc
+}
+
+// TrendingTag is a tag and how much it was used inside the window
+type TrendingTag struct {
+	Tag     string `json:"tag"`
+	Posts   int    `json:"posts"`
+	Authors int    `json:"authors"`
+}
+
+// GetPosts pages through the posts with tag, newest first, hiding the authors the viewer shouldn't see
+func (s *TagStore) GetPosts(ctx context.Context, viewerID int64, tag string, q PaginatedQuery) (*FeedPage, error) {
+	query := `
+	SELECT ` + feedColumns + `
+	FROM posts AS p
+	JOIN users AS u ON u.id = p.user_id
+	WHERE
+		p.tags @> ARRAY[$2::text] AND
+		p.user_id NOT IN (` + hiddenAuthorsOf + `) AND
+		($3::timestamptz IS NULL OR (p.created_at, p.id) < ($3, $4))
+	ORDER BY p.created_at DESC, p.id DESC
+	LIMIT $5
+	`
+
+	var cursorAt any
+	var cursorID int64
+	if q.Cursor != nil {
+		cursorAt, cursorID = q.Cursor.CreatedAt, q.Cursor.ID
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	posts, err := queryFeed(ctx, s.db, s.blobURL, query, []any{viewerID, tag, cursorAt, cursorID, q.Limit + 1}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &FeedPage{Posts: posts}
+	if len(posts) > q.Limit {
+		page.Posts = posts[:q.Limit]
+		last := page.Posts[q.Limit-1]
+		page.NextCursor = Cursor{CreatedAt: last.CreatedAt, ID: last.ID, Sort: "desc"}.Encode()
+	}
+
+	return page, nil
+}
+
+// Trending returns the tags used by the most different authors in the last window,
+// so one user spamming a tag can't push it to the top alone
+func (s *TagStore) Trending(ctx context.Context, window time.Duration, limit int) ([]TrendingTag, error) {
+	query := `
+		SELECT tag, COUNT(*) AS posts, COUNT(DISTINCT p.user_id) AS authors
+		FROM posts AS p
+		CROSS JOIN LATERAL unnest(p.tags) AS tag
+		WHERE p.created_at > $1
+		GROUP BY tag
+		ORDER BY authors DESC, posts DESC, tag ASC
+		LIMIT $2
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, time.Now().Add(-window), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := []TrendingTag{}
+	for rows.Next() {
+		var t TrendingTag
+		if err := rows.Scan(&t.Tag, &t.Posts, &t.Authors); err != nil {
+			return nil, err
+		}
+		tags = append(tags, t)
+	}
+
+	return tags, rows.Err()
+}
@@ -0,0 +1,213 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var (
+	ErrTokenReused  = errors.New("refresh token was already used")
+	ErrTokenExpired = errors.New("token expired")
+)
+
+// tokens database, refresh tokens and revoked access tokens
+type TokenStore struct {
+	db *sql.DB
+}
+
+type RefreshToken struct {
+	ID        int64      `json:"id"`
+	UserID    int64      `json:"user_id"`
+	TokenHash string     `json:"-"`
+	FamilyID  string     `json:"family_id"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	RevokedAt *time.Time `json:"revoked_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+func (s *TokenStore) CreateRefreshToken(ctx context.Context, token *RefreshToken) error {
+	return withTeransaction(s.db, ctx, func(tx *sql.Tx) error {
+		return s.createRefreshToken(ctx, tx, token)
+	})
+}
+
+// RotateRefreshToken revokes the token with oldHash and stores next in the same family.
+// presenting a token that was already rotated means it leaked, so we revoke the
+// whole family and return ErrTokenReused.
+func (s *TokenStore) RotateRefreshToken(ctx context.Context, oldHash string, next *RefreshToken) error {
+	reused := false
+
+	err := withTeransaction(s.db, ctx, func(tx *sql.Tx) error {
+		old, err := s.getRefreshTokenForUpdate(ctx, tx, oldHash)
+		if err != nil {
+			return err
+		}
+
+		if old.RevokedAt != nil {
+			reused = true
+			return s.revokeFamily(ctx, tx, old.FamilyID)
+		}
+
+		if time.Now().After(old.ExpiresAt) {
+			return ErrTokenExpired
+		}
+
+		if err := s.revokeRefreshToken(ctx, tx, old.ID); err != nil {
+			return err
+		}
+
+		next.UserID = old.UserID
+		next.FamilyID = old.FamilyID
+		return s.createRefreshToken(ctx, tx, next)
+	})
+	if err != nil {
+		return err
+	}
+
+	// the family revoke has to be committed, so we report the reuse only now
+	if reused {
+		return ErrTokenReused
+	}
+
+	return nil
+}
+
+// RevokeRefreshFamily revokes the token with hash and every token rotated from the same login
+func (s *TokenStore) RevokeRefreshFamily(ctx context.Context, hash string) error {
+	return withTeransaction(s.db, ctx, func(tx *sql.Tx) error {
+		token, err := s.getRefreshTokenForUpdate(ctx, tx, hash)
+		if err != nil {
+			return err
+		}
+
+		return s.revokeFamily(ctx, tx, token.FamilyID)
+	})
+}
+
+// RevokeAllForUser logs the user out of every device
+func (s *TokenStore) RevokeAllForUser(ctx context.Context, userID int64) error {
+	query := `UPDATE refresh_tokens SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, userID)
+	return err
+}
+
+// RevokeAccessToken blocks an access token until it expires on its own
+func (s *TokenStore) RevokeAccessToken(ctx context.Context, jti string, userID int64, expiresAt time.Time) error {
+	query := `INSERT INTO revoked_tokens (jti, user_id, expires_at) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, jti, userID, expiresAt)
+	return err
+}
+
+func (s *TokenStore) IsAccessTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	query := `SELECT EXISTS (SELECT 1 FROM revoked_tokens WHERE jti = $1)`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var revoked bool
+	err := s.db.QueryRowContext(ctx, query, jti).Scan(&revoked)
+	return revoked, err
+}
+
+// DeleteExpired removes rows that can't be used anymore anyway
+func (s *TokenStore) DeleteExpired(ctx context.Context) error {
+	return withTeransaction(s.db, ctx, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		if _, err := tx.ExecContext(ctx, `DELETE FROM revoked_tokens WHERE expires_at < NOW()`); err != nil {
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, `DELETE FROM refresh_tokens WHERE expires_at < NOW()`); err != nil {
+			return err
+		}
+
+		_, err := tx.ExecContext(ctx, `DELETE FROM password_resets WHERE expires_at < NOW()`)
+		return err
+	})
+}
+
+func (s *TokenStore) createRefreshToken(ctx context.Context, tx *sql.Tx, token *RefreshToken) error {
+	query := `
+		INSERT INTO refresh_tokens (user_id, token_hash, family_id, expires_at)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return tx.QueryRowContext(ctx, query,
+		token.UserID,
+		token.TokenHash,
+		token.FamilyID,
+		token.ExpiresAt,
+	).Scan(
+		&token.ID,
+		&token.CreatedAt,
+	)
+}
+
+func (s *TokenStore) getRefreshTokenForUpdate(ctx context.Context, tx *sql.Tx, hash string) (*RefreshToken, error) {
+	query := `
+		SELECT id, user_id, token_hash, family_id, expires_at, revoked_at, created_at
+		FROM refresh_tokens
+		WHERE token_hash = $1
+		FOR UPDATE
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	token := &RefreshToken{}
+	err := tx.QueryRowContext(ctx, query, hash).Scan(
+		&token.ID,
+		&token.UserID,
+		&token.TokenHash,
+		&token.FamilyID,
+		&token.ExpiresAt,
+		&token.RevokedAt,
+		&token.CreatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return token, nil
+}
+
+func (s *TokenStore) revokeRefreshToken(ctx context.Context, tx *sql.Tx, id int64) error {
+	query := `UPDATE refresh_tokens SET revoked_at = NOW() WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := tx.ExecContext(ctx, query, id)
+	return err
+}
+
+func (s *TokenStore) revokeFamily(ctx context.Context, tx *sql.Tx, familyID string) error {
+	query := `UPDATE refresh_tokens SET revoked_at = NOW() WHERE family_id = $1 AND revoked_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := tx.ExecContext(ctx, query, familyID)
+	return err
+}
@@ -6,6 +6,7 @@ import (
 	"database/sql"
 	"encoding/hex"
 	"errors"
+	"fmt"
 	"strings"
 	"time"
 
@@ -23,8 +24,71 @@ type User struct {
 	UserName  string    `json:"username"`
 	Email     string    `json:"email"`
 	Password  password  `json:"_"`
-	CreatedAt time.Time `json:"creaeted_at"`
+	CreatedAt time.Time `json:"created_at"`
 	IsActive  bool      `json:"is_active"`
+	RoleID    int64     `json:"role_id"`
+	Role      Role      `json:"role"`
+
+	// profile, every one of them can be empty
+	DisplayName string    `json:"display_name"`
+	Bio         string    `json:"bio"`
+	AvatarURL   string    `json:"avatar_url"`
+	Website     string    `json:"website"`
+	Location    string    `json:"location"`
+	UpdatedAt   time.Time `json:"updated_at"`
+
+	// goes up with every password change, access tokens with an older one are not valid anymore
+	TokenVersion int64 `json:"token_version"`
+}
+
+// PublicUser is what everyone can see about a user,
+// User itself is only for the user and has the private fields like the email
+type PublicUser struct {
+	ID          int64     `json:"id"`
+	UserName    string    `json:"username"`
+	DisplayName string    `json:"display_name"`
+	Bio         string    `json:"bio"`
+	AvatarURL   string    `json:"avatar_url"`
+	Website     string    `json:"website"`
+	Location    string    `json:"location"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// PrivateUser is what a user sees of themselves, the public view plus the email and account state.
+// the role and the token version stay on the server.
+type PrivateUser struct {
+	PublicUser
+	Email     string    `json:"email"`
+	IsActive  bool      `json:"is_active"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Author is the part of a user shown next to their posts and comments
+type Author struct {
+	ID       int64  `json:"id"`
+	UserName string `json:"username"`
+}
+
+func (u *User) Public() *PublicUser {
+	return &PublicUser{
+		ID:          u.ID,
+		UserName:    u.UserName,
+		DisplayName: u.DisplayName,
+		Bio:         u.Bio,
+		AvatarURL:   u.AvatarURL,
+		Website:     u.Website,
+		Location:    u.Location,
+		CreatedAt:   u.CreatedAt,
+	}
+}
+
+func (u *User) Private() *PrivateUser {
+	return &PrivateUser{
+		PublicUser: *u.Public(),
+		Email:      u.Email,
+		IsActive:   u.IsActive,
+		UpdatedAt:  u.UpdatedAt,
+	}
 }
 
 type password struct {
@@ -55,24 +119,40 @@ func (p *password) Compare(pass string) error {
 
 // CRUD users
 func (s *UserStore) Create(ctx context.Context, tx *sql.Tx, user *User) error {
-	query := `INSERT INTO users (username, email, password) VALUES($1, $2, $3) RETURNING id, created_at;`
-
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
 	defer cancel()
 
-	err := s.db.QueryRowContext(ctx, query,
+	if err := s.checkUsername(ctx, tx, user.UserName, 0); err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO users (username, email, password, role_id)
+		VALUES($1, $2, $3, (SELECT id FROM roles WHERE name = $4))
+		RETURNING id, created_at, role_id;
+	`
+
+	// every new user is a normal user unless someone said otherwise
+	role := user.Role.Name
+	if role == "" {
+		role = RoleUser
+	}
+
+	err := tx.QueryRowContext(ctx, query,
 		user.UserName,
 		user.Email,
 		user.Password.hash,
+		role,
 	).Scan(
 		&user.ID,
 		&user.CreatedAt,
+		&user.RoleID,
 	)
 	if err != nil {
 		switch {
 		case strings.Contains(err.Error(), "pq: duplicate key value violates unique constraint \"users_email_key\""):
 			return ErrDuplicatedEmail
-		case strings.Contains(err.Error(), "pq: duplicate key value violates unique constraint \"users_username_key\""):
+		case isDuplicatedUsername(err):
 			return ErrDuplicatedUsername
 		default:
 			return err
@@ -82,8 +162,35 @@ func (s *UserStore) Create(ctx context.Context, tx *sql.Tx, user *User) error {
 	return nil
 }
 
+// checkUsername returns ErrDuplicatedUsername when another user than exceptID has the
+// username in any case. the unique index on lower(username) catches two users taking it at once.
+func (s *UserStore) checkUsername(ctx context.Context, tx *sql.Tx, username string, exceptID int64) error {
+	var taken bool
+	query := `SELECT EXISTS (SELECT 1 FROM users WHERE lower(username) = lower($1) AND id <> $2)`
+	if err := tx.QueryRowContext(ctx, query, username, exceptID).Scan(&taken); err != nil {
+		return err
+	}
+	if taken {
+		return ErrDuplicatedUsername
+	}
+
+	return nil
+}
+
+func isDuplicatedUsername(err error) bool {
+	return strings.Contains(err.Error(), "pq: duplicate key value violates unique constraint \"users_username_key\"") ||
+		strings.Contains(err.Error(), "pq: duplicate key value violates unique constraint \"users_username_lower_key\"")
+}
+
 func (s *UserStore) GetById(ctx context.Context, id int64) (*User, error) {
-	query := `SELECT id, username, email, created_at FROM users WHERE id = $1`
+	query := `
+		SELECT
+			u.id, u.username, u.email, u.created_at, u.is_active, r.id, r.name, r.level, r.description,
+			u.display_name, u.bio, u.avatar_url, u.website, u.location, u.updated_at, u.token_version
+		FROM users AS u
+		JOIN roles AS r ON r.id = u.role_id
+		WHERE u.id = $1
+	`
 	user := &User{}
 
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
@@ -93,8 +200,19 @@ func (s *UserStore) GetById(ctx context.Context, id int64) (*User, error) {
 		&user.ID,
 		&user.UserName,
 		&user.Email,
-		&user.Password.hash,
 		&user.CreatedAt,
+		&user.IsActive,
+		&user.Role.ID,
+		&user.Role.Name,
+		&user.Role.Level,
+		&user.Role.Description,
+		&user.DisplayName,
+		&user.Bio,
+		&user.AvatarURL,
+		&user.Website,
+		&user.Location,
+		&user.UpdatedAt,
+		&user.TokenVersion,
 	)
 
 	if err != nil {
@@ -105,13 +223,14 @@ func (s *UserStore) GetById(ctx context.Context, id int64) (*User, error) {
 			return nil, err
 		}
 	}
+	user.RoleID = user.Role.ID
 
 	return user, nil
 }
 
 func (s *UserStore) Update(ctx context.Context, tx *sql.Tx, user *User) error {
 	query := `
-		UPDATE posts
+		UPDATE users
 			SET username = $1, email = $2, is_active = $3
 		WHERE id = $4
 	`
@@ -123,6 +242,50 @@ func (s *UserStore) Update(ctx context.Context, tx *sql.Tx, user *User) error {
 	return err
 }
 
+// UpdateProfile saves the username and the profile fields of the user.
+// usernames are unique no matter the case, a taken one returns ErrDuplicatedUsername.
+func (s *UserStore) UpdateProfile(ctx context.Context, user *User) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return withTeransaction(s.db, ctx, func(tx *sql.Tx) error {
+		if err := s.checkUsername(ctx, tx, user.UserName, user.ID); err != nil {
+			return err
+		}
+
+		query := `
+			UPDATE users
+			SET username = $1, display_name = $2, bio = $3, avatar_url = $4, website = $5, location = $6, updated_at = NOW()
+			WHERE id = $7
+			RETURNING updated_at
+		`
+		err := tx.QueryRowContext(
+			ctx,
+			query,
+			user.UserName,
+			user.DisplayName,
+			user.Bio,
+			user.AvatarURL,
+			user.Website,
+			user.Location,
+			user.ID,
+		).Scan(&user.UpdatedAt)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrNotFound
+			// two users taking the same name at the same time, the check above can't see it
+			case isDuplicatedUsername(err):
+				return ErrDuplicatedUsername
+			default:
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
 func (s *UserStore) CreateAndInvite(ctx context.Context, user *User, token string, invitationExp time.Duration) error {
 	return withTeransaction(s.db, ctx, func(tx *sql.Tx) error {
 		// cerate user
@@ -139,8 +302,10 @@ func (s *UserStore) CreateAndInvite(ctx context.Context, user *User, token strin
 	})
 }
 
-func (s *UserStore) Activate(ctx context.Context, token string) error {
-	return withTeransaction(s.db, ctx, func(tx *sql.Tx) error {
+// Activate activates the user who owns the invitation token and returns its id
+func (s *UserStore) Activate(ctx context.Context, token string) (int64, error) {
+	var userID int64
+	err := withTeransaction(s.db, ctx, func(tx *sql.Tx) error {
 		user, err := s.getUserFromInvitation(ctx, tx, token)
 		if err != nil {
 			return err
@@ -156,13 +321,38 @@ func (s *UserStore) Activate(ctx context.Context, token string) error {
 			return err
 		}
 
+		userID = user.ID
 		return nil
 	})
+
+	return userID, err
+}
+
+// Delete removes the user and its pending invitations, we use it to undo a
+// registration when the invitation email could not be sent
+func (s *UserStore) Delete(ctx context.Context, userID int64) error {
+	return withTeransaction(s.db, ctx, func(tx *sql.Tx) error {
+		if err := s.deleteUserInvitation(ctx, tx, userID); err != nil {
+			return err
+		}
+
+		return s.delete(ctx, tx, userID)
+	})
+}
+
+func (s *UserStore) delete(ctx context.Context, tx *sql.Tx, userID int64) error {
+	query := `DELETE FROM users WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := tx.ExecContext(ctx, query, userID)
+	return err
 }
 
 func (s *UserStore) GetByEmail(ctx context.Context, email string) (*User, error) {
 	query := `
-		SELECT id, username, email, password, created_at FROM users
+		SELECT id, username, email, password, created_at, token_version FROM users
 		WHERE email = $1 AND is_active = true
 	`
 
@@ -176,6 +366,7 @@ func (s *UserStore) GetByEmail(ctx context.Context, email string) (*User, error)
 		&user.Email,
 		&user.Password.hash,
 		&user.CreatedAt,
+		&user.TokenVersion,
 	)
 	if err != nil {
 		switch err {
@@ -189,6 +380,91 @@ func (s *UserStore) GetByEmail(ctx context.Context, email string) (*User, error)
 	return user, nil
 }
 
+// UpdatePassword saves the password of the user and bumps user.TokenVersion
+func (s *UserStore) UpdatePassword(ctx context.Context, user *User) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return withTeransaction(s.db, ctx, func(tx *sql.Tx) error {
+		if err := s.updatePassword(ctx, tx, user); err != nil {
+			return err
+		}
+
+		// a reset link sent before the change must not undo it
+		return s.deletePasswordResets(ctx, tx, user.ID)
+	})
+}
+
+// CreatePasswordReset stores the hash of a reset token, the older tokens of the user stop working
+func (s *UserStore) CreatePasswordReset(ctx context.Context, userID int64, tokenHash string, exp time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return withTeransaction(s.db, ctx, func(tx *sql.Tx) error {
+		if err := s.deletePasswordResets(ctx, tx, userID); err != nil {
+			return err
+		}
+
+		query := `INSERT INTO password_resets (token_hash, user_id, expires_at) VALUES ($1, $2, $3)`
+		_, err := tx.ExecContext(ctx, query, tokenHash, userID, time.Now().Add(exp))
+		return err
+	})
+}
+
+// ResetPassword sets the password of the user who owns the reset token to user.Password
+// and fills user.ID. the token can only be used once, unknown and expired ones return ErrNotFound.
+func (s *UserStore) ResetPassword(ctx context.Context, tokenHash string, user *User) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return withTeransaction(s.db, ctx, func(tx *sql.Tx) error {
+		query := `
+			DELETE FROM password_resets
+			WHERE token_hash = $1 AND expires_at > NOW()
+			RETURNING user_id
+		`
+		if err := tx.QueryRowContext(ctx, query, tokenHash).Scan(&user.ID); err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrNotFound
+			default:
+				return err
+			}
+		}
+
+		if err := s.updatePassword(ctx, tx, user); err != nil {
+			return err
+		}
+
+		return s.deletePasswordResets(ctx, tx, user.ID)
+	})
+}
+
+func (s *UserStore) updatePassword(ctx context.Context, tx *sql.Tx, user *User) error {
+	query := `
+		UPDATE users SET password = $1, token_version = token_version + 1
+		WHERE id = $2 AND is_active = true
+		RETURNING token_version
+	`
+
+	err := tx.QueryRowContext(ctx, query, user.Password.hash, user.ID).Scan(&user.TokenVersion)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrNotFound
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *UserStore) deletePasswordResets(ctx context.Context, tx *sql.Tx, userID int64) error {
+	_, err := tx.ExecContext(ctx, `DELETE FROM password_resets WHERE user_id = $1`, userID)
+	return err
+}
+
 func (s *UserStore) createUserInvitation(ctx context.Context, tx *sql.Tx, token string, invitationExp time.Duration, userID int64) error {
 	query := `INSERT INTO user_invitations (token, user_id, expiry) VALUES ($1, $2, $3);`
 
@@ -203,7 +479,7 @@ func (s *UserStore) createUserInvitation(ctx context.Context, tx *sql.Tx, token
 }
 
 func (s *UserStore) deleteUserInvitation(ctx context.Context, tx *sql.Tx, userID int64) error {
-	query := `DELETE FROM user_invitations WHERE id = $1;`
+	query := `DELETE FROM user_invitations WHERE user_id = $1;`
 
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
@@ -217,10 +493,10 @@ func (s *UserStore) deleteUserInvitation(ctx context.Context, tx *sql.Tx, userID
 
 func (s *UserStore) getUserFromInvitation(ctx context.Context, tx *sql.Tx, token string) (*User, error) {
 	query := `
-	SELECT u.id, u.email, u.created_at, u.is_active
+	SELECT u.id, u.username, u.email, u.created_at, u.is_active
 	FROM users u
-	JOIN users_invitations ui ON u.id = uiuser_id
-	WHERE ui.token = $1 AND ui.expiry = $2
+	JOIN user_invitations ui ON u.id = ui.user_id
+	WHERE ui.token = $1 AND ui.expiry > $2
 	`
 
 	ctx, cancel := context.WithCancel(ctx)
@@ -248,3 +524,86 @@ func (s *UserStore) getUserFromInvitation(ctx context.Context, tx *sql.Tx, token
 
 	return user, nil
 }
+
+// CreateBatch inserts all users with a single query, used by the seeder
+func (s *UserStore) CreateBatch(ctx context.Context, tx *sql.Tx, users []*User) error {
+	if len(users) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	roleIDs, err := s.roleIDs(ctx, tx)
+	if err != nil {
+		return err
+	}
+
+	args := make([]any, 0, len(users)*5)
+	for _, u := range users {
+		role := u.Role.Name
+		if role == "" {
+			role = RoleUser
+		}
+		roleID, ok := roleIDs[role]
+		if !ok {
+			return fmt.Errorf("unknown role %q", role)
+		}
+		args = append(args, u.UserName, u.Email, u.Password.hash, u.IsActive, roleID)
+	}
+
+	query := `
+		INSERT INTO users (username, email, password, is_active, role_id)
+		VALUES ` + placeholders(len(users), 5) + `
+		RETURNING username, id, created_at, role_id
+	`
+
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	// the order of RETURNING is not guaranteed, usernames are unique so they find the user
+	byUsername := make(map[string]*User, len(users))
+	for _, u := range users {
+		byUsername[u.UserName] = u
+	}
+
+	for rows.Next() {
+		var username string
+		var id, roleID int64
+		var createdAt time.Time
+		if err := rows.Scan(&username, &id, &createdAt, &roleID); err != nil {
+			return err
+		}
+
+		u, ok := byUsername[username]
+		if !ok {
+			return fmt.Errorf("batch insert returned unknown username %q", username)
+		}
+		u.ID, u.CreatedAt, u.RoleID = id, createdAt, roleID
+	}
+
+	return rows.Err()
+}
+
+func (s *UserStore) roleIDs(ctx context.Context, tx *sql.Tx) (map[string]int64, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT name, id FROM roles`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := map[string]int64{}
+	for rows.Next() {
+		var name string
+		var id int64
+		if err := rows.Scan(&name, &id); err != nil {
+			return nil, err
+		}
+		ids[name] = id
+	}
+
+	return ids, rows.Err()
+}
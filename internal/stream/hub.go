@@ -0,0 +1,188 @@
+// Package stream is an in-process pub/sub hub that fans events out to the
+// connections of a user and keeps a short replay buffer per user, so a client
+// that reconnects with Last-Event-ID doesn't miss what happened in between.
+package stream
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// Event is one server-sent event, ID only ever grows (also across restarts)
+type Event struct {
+	ID   uint64
+	Type string
+	Data json.RawMessage
+}
+
+type Config struct {
+	ReplaySize   int           // events kept per user for Last-Event-ID resumes
+	IdleTTL      time.Duration // how long the buffer of a disconnected user is kept
+	SubscriberCh int           // buffered events per connection before it is dropped as too slow
+}
+
+// Hub is safe for concurrent use
+type Hub struct {
+	cfg    Config
+	mu     sync.Mutex
+	lastID uint64
+	users  map[int64]*userStream
+	closed bool
+}
+
+type userStream struct {
+	subs       map[*Subscription]struct{}
+	replay     []Event // oldest first, at most cfg.ReplaySize
+	lastActive time.Time
+}
+
+// Subscription is one connection of a user, Events is closed when the hub
+// drops it (slow reader or shutdown) and after Close
+type Subscription struct {
+	Events <-chan Event
+	events chan Event
+	hub    *Hub
+	userID int64
+	once   sync.Once
+}
+
+func NewHub(cfg Config) *Hub {
+	return &Hub{
+		cfg: cfg,
+		// starting from the clock keeps ids growing after a restart,
+		// so old Last-Event-IDs never look like they are from the future
+		lastID: uint64(time.Now().UnixMicro()),
+		users:  map[int64]*userStream{},
+	}
+}
+
+// Subscribe starts listening for the events of userID. events newer than lastEventID
+// that are still in the replay buffer are returned so they can be sent first.
+func (h *Hub) Subscribe(userID int64, lastEventID uint64) (*Subscription, []Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	events := make(chan Event, h.cfg.SubscriberCh)
+	sub := &Subscription{Events: events, events: events, hub: h, userID: userID}
+	if h.closed {
+		close(events)
+		return sub, nil
+	}
+
+	us, ok := h.users[userID]
+	if !ok {
+		us = &userStream{subs: map[*Subscription]struct{}{}}
+		h.users[userID] = us
+	}
+	us.subs[sub] = struct{}{}
+	us.lastActive = time.Now()
+
+	var missed []Event
+	if lastEventID > 0 {
+		for _, e := range us.replay {
+			if e.ID > lastEventID {
+				missed = append(missed, e)
+			}
+		}
+	}
+
+	return sub, missed
+}
+
+// Close stops the subscription, it is safe to call more than once
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+
+	s.hub.remove(s)
+}
+
+// remove must be called with the lock held
+func (h *Hub) remove(s *Subscription) {
+	s.once.Do(func() {
+		if us, ok := h.users[s.userID]; ok {
+			delete(us.subs, s)
+			us.lastActive = time.Now()
+		}
+		close(s.events)
+	})
+}
+
+// Publish sends an event to every connection of userID. users that were not
+// connected lately have no buffer and their events are dropped, they will
+// load them from the api when they come back.
+func (h *Hub) Publish(userID int64, eventType string, data any) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	us, ok := h.users[userID]
+	if !ok || h.closed {
+		return nil
+	}
+
+	h.lastID++
+	event := Event{ID: h.lastID, Type: eventType, Data: payload}
+
+	us.replay = append(us.replay, event)
+	if len(us.replay) > h.cfg.ReplaySize {
+		us.replay = us.replay[len(us.replay)-h.cfg.ReplaySize:]
+	}
+
+	for sub := range us.subs {
+		select {
+		case sub.events <- event:
+		default:
+			// a reader this far behind is dropped, it resumes with Last-Event-ID
+			h.remove(sub)
+		}
+	}
+
+	return nil
+}
+
+// Run forgets the buffers of users that have been gone for longer than IdleTTL,
+// it returns when ctx is done
+func (h *Hub) Run(ctx context.Context) {
+	ticker := time.NewTicker(h.cfg.IdleTTL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			h.sweep(time.Now())
+		}
+	}
+}
+
+func (h *Hub) sweep(now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for userID, us := range h.users {
+		if len(us.subs) == 0 && now.Sub(us.lastActive) > h.cfg.IdleTTL {
+			delete(h.users, userID)
+		}
+	}
+}
+
+// Close drops every subscription so open streams end, used on shutdown
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.closed = true
+	for _, us := range h.users {
+		for sub := range us.subs {
+			h.remove(sub)
+		}
+	}
+}